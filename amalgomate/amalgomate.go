@@ -37,8 +37,23 @@ func Run(cfg Config, outputDir, pkg string) error {
 		outputDir = path.Join(wd, outputDir)
 	}
 
+	// verify that the modules to repackage do not require a newer Go version or toolchain than the host module. Performed
+	// before repackaging so that the output is not modified if the check fails.
+	projectModuleInfo, err := moduleInfoForDirectory(outputDir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to determine module for directory %s", outputDir)
+	}
+	srcModules, err := sourceModules(cfg, outputDir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve modules specified in configuration")
+	}
+	if err := reportGoVersions(projectModuleInfo.Dir, srcModules, cfg.GoVersionPolicy); err != nil {
+		return err
+	}
+
 	// repackage main files specified in configuration
-	if err := repackage(cfg, outputDir); err != nil {
+	modules, err := repackage(cfg, outputDir)
+	if err != nil {
 		return errors.Wrapf(err, "failed to repackage files specified in configuration")
 	}
 
@@ -49,6 +64,12 @@ func Run(cfg Config, outputDir, pkg string) error {
 		}
 	}
 
+	// verify that the host module requires the dependencies of the repackaged modules. Performed last because updating
+	// the go.mod file of the host module may require go.sum entries that do not exist yet.
+	if err := reconcileRequirements(projectModuleInfo.Dir, modules, cfg.UpdateGoMod, buildOptions{Tags: cfg.BuildTags, Platforms: cfg.Platforms}); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -65,10 +86,19 @@ import (
 var programs = map[string]func() {
 }
 
+var hiddenPrograms = map[string]bool {
+}
+
+var defaultProgram = ""
+
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("Missing program argument. Valid values:", cmds())
-		os.Exit(1)
+		if defaultProgram == "" {
+			fmt.Println("Missing program argument. Valid values:", cmds())
+			os.Exit(1)
+		}
+		programs[defaultProgram]()
+		return
 	}
 
 	programName := os.Args[1]
@@ -84,6 +114,9 @@ func main() {
 func cmds() []string {
 	var cmds []string
 	for key := range programs {
+		if hiddenPrograms[key] {
+			continue
+		}
 		cmds = append(cmds, key)
 	}
 	sort.Strings(cmds)
@@ -94,34 +127,151 @@ func cmds() []string {
 package amalgomated
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"sort"
 )
 
+// module refers to the functions of the packages that are written to the root of the repackaged module of a program.
+// The functions of the packages that were not generated are nil.
+type module struct {
+	reset      func()
+	redirect   func(stdin io.Reader, stdout, stderr io.Writer) (restore func())
+	setEnv     func(env map[string]string) (restore func())
+	setDir     func(dir string) (restore func())
+	setContext func(ctx context.Context) (restore func())
+	lock       func() (unlock func())
+}
+
 var programs = map[string]func() {
 }
 
+var hiddenPrograms = map[string]bool {
+}
+
+var modules = map[string]module {
+}
+
+var defaultProgram = ""
+
 func Instance() Amalgomated {
 	return &amalgomated{}
 }
 
 type Amalgomated interface {
 	Run(cmd string)
+	RunWithOptions(ctx context.Context, cmd string, opts ...Option)
 	Cmds() []string
 }
 
+// Option configures an invocation of a program by RunWithOptions.
+type Option func(*options)
+
+type options struct {
+	redirectIO     bool
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	setEnv         bool
+	env            map[string]string
+	setDir         bool
+	dir            string
+}
+
+// WithIO returns an option that runs the program with the provided standard streams. Streams that are nil are not
+// changed. The library must have been generated with redirect-stdio.
+func WithIO(stdin io.Reader, stdout, stderr io.Writer) Option {
+	return func(o *options) {
+		o.redirectIO, o.stdin, o.stdout, o.stderr = true, stdin, stdout, stderr
+	}
+}
+
+// WithEnv returns an option that runs the program with the provided environment. The library must have been generated
+// with redirect-env.
+func WithEnv(env map[string]string) Option {
+	return func(o *options) {
+		o.setEnv, o.env = true, env
+	}
+}
+
+// WithDir returns an option that runs the program in the provided working directory. The library must have been
+// generated with redirect-wd.
+func WithDir(dir string) Option {
+	return func(o *options) {
+		o.setDir, o.dir = true, dir
+	}
+}
+
 type amalgomated struct{}
 
 func (a *amalgomated) Run(cmd string) {
+	a.RunWithOptions(context.Background(), cmd)
+}
+
+// RunWithOptions runs the provided program with the provided context and options. The context can only be cancelled
+// if the library was generated with propagate-context. Panics if an option (or a context that can be cancelled) is
+// provided for a program whose module was not generated with the corresponding feature.
+func (a *amalgomated) RunWithOptions(ctx context.Context, cmd string, opts ...Option) {
+	cmd = a.resolve(cmd)
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	m := modules[cmd]
+	var setup []func() (restore func())
+	if m.setContext != nil {
+		setup = append(setup, func() func() { return m.setContext(ctx) })
+	} else if ctx.Done() != nil {
+		panic(fmt.Sprintf("Context of command \"%v\" cannot be set", cmd))
+	}
+	if o.redirectIO {
+		if m.redirect == nil {
+			panic(fmt.Sprintf("Standard streams of command \"%v\" cannot be redirected", cmd))
+		}
+		setup = append(setup, func() func() { return m.redirect(o.stdin, o.stdout, o.stderr) })
+	}
+	if o.setEnv {
+		if m.setEnv == nil {
+			panic(fmt.Sprintf("Environment of command \"%v\" cannot be set", cmd))
+		}
+		setup = append(setup, func() func() { return m.setEnv(o.env) })
+	}
+	if o.setDir {
+		if m.setDir == nil {
+			panic(fmt.Sprintf("Working directory of command \"%v\" cannot be set", cmd))
+		}
+		setup = append(setup, func() func() { return m.setDir(o.dir) })
+	}
+
+	if m.lock != nil {
+		defer m.lock()()
+	}
+	if m.reset != nil {
+		m.reset()
+	}
+	for _, set := range setup {
+		defer set()()
+	}
+	programs[cmd]()
+}
+
+func (a *amalgomated) resolve(cmd string) string {
+	if cmd == "" && defaultProgram != "" {
+		cmd = defaultProgram
+	}
 	if _, ok := programs[cmd]; !ok {
 		panic(fmt.Sprintf("Unknown command: \"%v\". Valid values: %v", cmd, a.Cmds()))
 	}
-	programs[cmd]()
+	return cmd
 }
 
 func (a *amalgomated) Cmds() []string {
 	var cmds []string
 	for key := range programs {
+		if hiddenPrograms[key] {
+			continue
+		}
 		cmds = append(cmds, key)
 	}
 	sort.Strings(cmds)
@@ -154,6 +304,17 @@ func writeOutputGoFile(config Config, outputDir, packageName string) error {
 	if err := setVarCompositeLiteralElements(file, "programs", createMapLiteralEntries(config.Pkgs)); err != nil {
 		return errors.Wrap(err, "failed to add const elements")
 	}
+	if err := setVarCompositeLiteralElements(file, "hiddenPrograms", createSetLiteralEntries(hiddenProgramNames(config.Pkgs))); err != nil {
+		return errors.Wrap(err, "failed to add hidden program elements")
+	}
+	if packageName != "main" {
+		if err := setVarCompositeLiteralElements(file, "modules", createModulesMapLiteralEntries(config.Pkgs, libraryModulePackages(config))); err != nil {
+			return errors.Wrap(err, "failed to add module elements")
+		}
+	}
+	if err := setVarStringValue(file, "defaultProgram", config.DefaultProgram); err != nil {
+		return errors.Wrap(err, "failed to set default program")
+	}
 
 	// write output to in-memory buffer and add import spaces
 	var byteBuffer bytes.Buffer
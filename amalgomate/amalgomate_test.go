@@ -0,0 +1,1212 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nmiyake/pkg/gofiles"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRunLocalDirectory verifies that an entry that specifies a directory relative to the configuration file is
+// amalgomated using the module that contains the directory even though the module is not required by the output module.
+func TestRunLocalDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/cmd/tool/main.go",
+			Src: `package main
+
+import (
+	"fmt"
+
+	"example.com/tool/internal/message"
+)
+
+func main() {
+	fmt.Println(message.Message)
+}
+`,
+		},
+		{
+			RelPath: "tool/internal/message/message.go",
+			Src:     "package message\n\nconst Message = \"local tool\"\n",
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "project/amalgomate.yml",
+			Src: `packages:
+  tool:
+    dir: ../tool/cmd/tool
+`,
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+	outputDir := filepath.Join(projectDir, "amalgomated")
+
+	// load configuration from a different working directory to verify that the directory is resolved relative to the
+	// configuration file
+	t.Chdir(tmpDir)
+	cfg, err := LoadConfig(filepath.Join("project", "amalgomate.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tmpDir, "tool", "cmd", "tool"), cfg.Pkgs["tool"].Dir)
+
+	err = Run(cfg, outputDir, "main")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(outputDir, "internal", "example.com", "tool", "internal", "message", "message.go"))
+
+	goModContent, err := os.ReadFile(filepath.Join(projectDir, "go.mod"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(goModContent), "example.com/tool", "project module should not require the local module")
+
+	goRunCmd := exec.Command("go", "run", "./amalgomated", "tool")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, "local tool", strings.TrimSpace(string(output)))
+}
+
+// TestRunEntrypointName verifies that a program whose main package already declares a function with the default
+// entrypoint name can be amalgomated by configuring a different package and entrypoint name.
+func TestRunEntrypointName(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import "fmt"
+
+func main() {
+	AmalgomatedMain()
+}
+
+func AmalgomatedMain() {
+	fmt.Println("already amalgomated")
+}
+`,
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+	outputDir := filepath.Join(projectDir, "amalgomated")
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir:            filepath.Join(tmpDir, "tool"),
+				PackageName:    "tool",
+				EntrypointName: "ToolMain",
+			},
+		},
+	}, outputDir, "main")
+	require.NoError(t, err)
+
+	mainContent, err := os.ReadFile(filepath.Join(outputDir, "internal", "example.com", "tool", "main.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(mainContent), "package tool\n")
+	assert.Contains(t, string(mainContent), "func ToolMain() {")
+
+	goRunCmd := exec.Command("go", "run", "./amalgomated", "tool")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, "already amalgomated", strings.TrimSpace(string(output)))
+}
+
+// TestRunLazyInit verifies that when LazyInit is true, the init functions of a repackaged module run in their original
+// order only when one of its programs is invoked.
+func TestRunLazyInit(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import (
+	"fmt"
+
+	"example.com/tool/greeting"
+)
+
+func init() {
+	fmt.Println("tool init")
+}
+
+func main() {
+	fmt.Println(greeting.Greeting)
+}
+`,
+		},
+		{
+			RelPath: "tool/greeting/greeting.go",
+			Src: `package greeting
+
+import "fmt"
+
+var Greeting = "hello"
+
+func init() {
+	fmt.Println("greeting init")
+	Greeting += ", world"
+}
+`,
+		},
+		{
+			RelPath: "other/go.mod",
+			Src:     "module example.com/other\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "other/main.go",
+			Src: `package main
+
+import "fmt"
+
+func init() {
+	fmt.Println("other init")
+}
+
+func main() {}
+`,
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+	outputDir := filepath.Join(projectDir, "amalgomated")
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"other": {
+				Dir: filepath.Join(tmpDir, "other"),
+			},
+			"tool": {
+				Dir: filepath.Join(tmpDir, "tool"),
+			},
+		},
+		LazyInit: true,
+	}, outputDir, "main")
+	require.NoError(t, err)
+
+	goRunCmd := exec.Command("go", "run", "./amalgomated", "tool")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, "greeting init\ntool init\nhello, world\n", string(output))
+}
+
+// TestRunResetVariables verifies that a program of a library generated with ResetVariables can be run multiple times in
+// the same process: package-level variables (including flags) are restored in initialization order before each
+// invocation, and init functions are run again only if RerunInits is true.
+func TestRunResetVariables(t *testing.T) {
+	for name, tc := range map[string]struct {
+		lazyInit   bool
+		rerunInits bool
+		want       string
+	}{
+		"eager init":             {want: "hello, first 1 [a b]\nhello, second 1 [a]\n"},
+		"lazy init":              {lazyInit: true, want: "hello, first 1 [a b]\nhello, second 1 [a]\n"},
+		"eager init rerun inits": {rerunInits: true, want: "hello, first 1 [a b]\nhello, second 1 [a b]\n"},
+		"lazy init rerun inits":  {lazyInit: true, rerunInits: true, want: "hello, first 1 [a b]\nhello, second 1 [a b]\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+				{
+					RelPath: "tool/go.mod",
+					Src:     "module example.com/tool\n\ngo 1.21\n",
+				},
+				{
+					RelPath: "tool/main.go",
+					Src: `package main
+
+import (
+	"flag"
+	"fmt"
+
+	"example.com/tool/counter"
+)
+
+var name = flag.String("name", "world", "name to greet")
+
+func main() {
+	flag.Parse()
+	counter.Count++
+	fmt.Printf("%s %s %d %v\n", counter.Prefix, *name, counter.Count, counter.Names)
+	counter.Greeting = "goodbye"
+}
+`,
+				},
+				{
+					RelPath: "tool/counter/a.go",
+					Src: `package counter
+
+// Prefix is declared in a file that precedes the declaration of Greeting
+var Prefix = Greeting + ","
+`,
+				},
+				{
+					RelPath: "tool/counter/counter.go",
+					Src: `package counter
+
+var (
+	Count    int
+	Greeting = "hello"
+	Names    = []string{"a"}
+)
+
+func init() {
+	Names = append(Names, "b")
+}
+`,
+				},
+				{
+					RelPath: "project/go.mod",
+					Src:     "module example.com/project\n\ngo 1.21\n",
+				},
+				{
+					RelPath: "project/main.go",
+					Src: `package main
+
+import (
+	"os"
+
+	"example.com/project/amalgomated"
+)
+
+func main() {
+	os.Args = []string{"tool", "-name", "first"}
+	amalgomated.Instance().Run("tool")
+	os.Args = []string{"tool", "-name", "second"}
+	amalgomated.Instance().Run("tool")
+}
+`,
+				},
+			})
+			require.NoError(t, err)
+			projectDir := filepath.Join(tmpDir, "project")
+
+			err = Run(Config{
+				Pkgs: map[string]SrcPkg{
+					"tool": {
+						Dir: filepath.Join(tmpDir, "tool"),
+					},
+				},
+				LazyInit:       tc.lazyInit,
+				ResetVariables: true,
+				RerunInits:     tc.rerunInits,
+			}, filepath.Join(projectDir, "amalgomated"), "amalgomated")
+			require.NoError(t, err)
+
+			goRunCmd := exec.Command("go", "run", ".")
+			goRunCmd.Dir = projectDir
+			output, err := goRunCmd.CombinedOutput()
+			require.NoError(t, err, "Output: %s", string(output))
+			assert.Equal(t, tc.want, string(output))
+		})
+	}
+}
+
+// TestRunRedirectStdio verifies that the standard streams of a program of a library generated with RedirectStdio are
+// redirected to the reader and writers provided to WithIO, including the output of the "fmt", "log" and "flag"
+// packages, and that a writer that cannot be compared can be provided for both output streams.
+func TestRunRedirectStdio(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	stdos "os"
+)
+
+func main() {
+	flag.String("name", "", "name to greet")
+	scanner := bufio.NewScanner(stdos.Stdin)
+	for scanner.Scan() {
+		fmt.Println("hello,", scanner.Text())
+	}
+	fmt.Fprintln(stdos.Stderr, "stderr output")
+	log.SetFlags(0)
+	log.Print("log output")
+	flag.PrintDefaults()
+}
+`,
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "project/main.go",
+			Src: `package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"example.com/project/amalgomated"
+)
+
+// writerFunc is a writer whose values cannot be compared.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func main() {
+	for _, name := range []string{"first", "second"} {
+		var stdout, stderr bytes.Buffer
+		amalgomated.Instance().RunWithOptions(context.Background(), "tool", amalgomated.WithIO(strings.NewReader(name+"\n"), &stdout, &stderr))
+		fmt.Printf("stdout: %q\nstderr: %q\n", stdout.String(), stderr.String())
+	}
+
+	var mu sync.Mutex
+	var output bytes.Buffer
+	w := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return output.Write(p)
+	})
+	amalgomated.Instance().RunWithOptions(context.Background(), "tool", amalgomated.WithIO(strings.NewReader("third\n"), w, w))
+	fmt.Println("same non-comparable writer:", strings.Contains(output.String(), "hello, third\n"), strings.Contains(output.String(), "log output\n"))
+	fmt.Println("standard logger unchanged:", log.Writer() == os.Stderr && log.Flags() == log.LstdFlags)
+}
+`,
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir: filepath.Join(tmpDir, "tool"),
+			},
+		},
+		RedirectStdio:  true,
+		ResetVariables: true,
+	}, filepath.Join(projectDir, "amalgomated"), "amalgomated")
+	require.NoError(t, err)
+
+	goRunCmd := exec.Command("go", "run", ".")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	wantStderr := `stderr output\nlog output\n  -name string\n    \tname to greet\n`
+	assert.Equal(t, `stdout: "hello, first\n"
+stderr: "`+wantStderr+`"
+stdout: "hello, second\n"
+stderr: "`+wantStderr+`"
+same non-comparable writer: true true
+standard logger unchanged: true
+`, string(output))
+}
+
+// TestRunRedirectEnv verifies that a program of a library generated with RedirectEnv (and the child processes that it
+// starts) use the environment provided to WithEnv and that changes it makes to the environment do not affect the
+// environment of the process.
+func TestRunRedirectEnv(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func main() {
+	_, ok := os.LookupEnv("HOME")
+	fmt.Println(os.Getenv("NAME"), ok)
+	_ = os.Setenv("NAME", "changed")
+	fmt.Println(os.ExpandEnv("$NAME"), os.Environ())
+	output, err := exec.Command("sh", "-c", "echo child $NAME").Output()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Print(string(output))
+}
+`,
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "project/main.go",
+			Src: `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"example.com/project/amalgomated"
+)
+
+func main() {
+	_ = os.Setenv("NAME", "process")
+	env := map[string]string{"NAME": "first"}
+	amalgomated.Instance().RunWithOptions(context.Background(), "tool", amalgomated.WithEnv(env))
+	fmt.Println(os.Getenv("NAME"), env["NAME"])
+}
+`,
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir: filepath.Join(tmpDir, "tool"),
+			},
+		},
+		RedirectEnv: true,
+	}, filepath.Join(projectDir, "amalgomated"), "amalgomated")
+	require.NoError(t, err)
+
+	goRunCmd := exec.Command("go", "run", ".")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, "first false\nchanged [NAME=changed]\nchild changed\nprocess first\n", string(output))
+}
+
+// TestRunSerializesModuleInvocations verifies that concurrent invocations of the programs of a module through a
+// library generated with RedirectEnv are serialized, so that each invocation uses its own environment and the
+// environment of the module is restored once all of them have completed.
+func TestRunSerializesModuleInvocations(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	first := os.Getenv("NAME")
+	time.Sleep(100 * time.Millisecond)
+	fmt.Printf("[%s %s]\n", first, os.Getenv("NAME"))
+}
+`,
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "project/main.go",
+			Src: `package main
+
+import (
+	"context"
+	"sync"
+
+	"example.com/project/amalgomated"
+)
+
+func main() {
+	var wg sync.WaitGroup
+	for _, name := range []string{"one", "two", "three"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			amalgomated.Instance().RunWithOptions(context.Background(), "tool", amalgomated.WithEnv(map[string]string{"NAME": name}))
+		}(name)
+	}
+	wg.Wait()
+	amalgomated.Instance().Run("tool")
+}
+`,
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir: filepath.Join(tmpDir, "tool"),
+			},
+		},
+		RedirectEnv: true,
+	}, filepath.Join(projectDir, "amalgomated"), "amalgomated")
+	require.NoError(t, err)
+
+	goRunCmd := exec.Command("go", "run", ".")
+	goRunCmd.Dir = projectDir
+	goRunCmd.Env = append(os.Environ(), "NAME=")
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	require.Len(t, lines, 4, "Output: %s", string(output))
+	concurrentLines := lines[:3]
+	sort.Strings(concurrentLines)
+	assert.Equal(t, []string{"[one one]", "[three three]", "[two two]"}, concurrentLines)
+	assert.Equal(t, "[ ]", lines[3])
+}
+
+// TestRunRedirectWd verifies that a program of a library generated with RedirectWd resolves relative paths against the
+// directory provided to WithDir (in which it also starts child processes) without changing the working directory of the
+// process.
+func TestRunRedirectWd(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	wd, _ := os.Getwd()
+	input, err := os.ReadFile("input.txt")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile("output.txt", append(input, "output"...), 0644); err != nil {
+		panic(err)
+	}
+	abs, _ := filepath.Abs("output.txt")
+	fmt.Println(filepath.Base(wd), filepath.Base(filepath.Dir(abs)))
+	_, err = os.Stat("missing.txt")
+	fmt.Println(err)
+	fmt.Println(os.Rename("missing.txt", "renamed.txt"))
+	childWd, err := exec.Command("pwd").Output()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("child", filepath.Base(strings.TrimSpace(string(childWd))))
+}
+`,
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "project/main.go",
+			Src: `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"example.com/project/amalgomated"
+)
+
+func main() {
+	amalgomated.Instance().RunWithOptions(context.Background(), "tool", amalgomated.WithDir(os.Args[1]))
+	output, err := os.ReadFile(filepath.Join(os.Args[1], "output.txt"))
+	if err != nil {
+		panic(err)
+	}
+	wd, _ := os.Getwd()
+	fmt.Println(string(output), filepath.Base(wd))
+}
+`,
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "workdir"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "workdir", "input.txt"), []byte("input "), 0644))
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir: filepath.Join(tmpDir, "tool"),
+			},
+		},
+		RedirectWd: true,
+	}, filepath.Join(projectDir, "amalgomated"), "amalgomated")
+	require.NoError(t, err)
+
+	goRunCmd := exec.Command("go", "run", ".", filepath.Join(tmpDir, "workdir"))
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, "workdir workdir\nstat missing.txt: no such file or directory\nrename missing.txt renamed.txt: no such file or directory\nchild workdir\ninput output project\n", string(output))
+}
+
+// TestRunPropagateContext verifies that a program of a library generated with PropagateContext uses the context
+// provided to RunWithOptions and receives the signals it registered for when the context is cancelled, and that it
+// receives the signals of the process when it is run with a context that cannot be cancelled.
+func TestRunPropagateContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	if context.Background().Done() == nil {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		defer signal.Stop(c)
+		p, _ := os.FindProcess(os.Getpid())
+		_ = p.Signal(os.Interrupt)
+		fmt.Println("received", <-c)
+		return
+	}
+	fmt.Println(context.TODO().Value("key"))
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+	fmt.Println("received", <-c)
+}
+`,
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "project/main.go",
+			Src: `package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"example.com/project/amalgomated"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "key", "value"))
+	time.AfterFunc(100*time.Millisecond, cancel)
+	amalgomated.Instance().RunWithOptions(ctx, "tool")
+	fmt.Println("returned")
+	amalgomated.Instance().Run("tool")
+}
+`,
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir: filepath.Join(tmpDir, "tool"),
+			},
+		},
+		PropagateContext: true,
+	}, filepath.Join(projectDir, "amalgomated"), "amalgomated")
+	require.NoError(t, err)
+
+	goRunCmd := exec.Command("go", "run", ".")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, "value\nreceived terminated\nreturned\nreceived interrupt\n", string(output))
+}
+
+// TestRunWithOptions verifies that RunWithOptions applies all of the provided options and panics if an option is
+// provided that the library was not generated to support.
+func TestRunWithOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println("hello,", os.Getenv("NAME"))
+}
+`,
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "project/main.go",
+			Src: `package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"example.com/project/amalgomated"
+)
+
+func main() {
+	var stdout bytes.Buffer
+	amalgomated.Instance().RunWithOptions(context.Background(), "tool", amalgomated.WithIO(nil, &stdout, nil), amalgomated.WithEnv(map[string]string{"NAME": "options"}))
+	fmt.Printf("stdout: %q\n", stdout.String())
+
+	for _, opt := range []amalgomated.Option{amalgomated.WithDir("."), amalgomated.WithEnv(nil)} {
+		func() {
+			defer func() {
+				fmt.Println("panic:", recover())
+			}()
+			amalgomated.Instance().RunWithOptions(context.Background(), "tool", amalgomated.WithIO(nil, &stdout, nil), opt)
+		}()
+	}
+}
+`,
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir: filepath.Join(tmpDir, "tool"),
+			},
+		},
+		RedirectStdio: true,
+		RedirectEnv:   true,
+	}, filepath.Join(projectDir, "amalgomated"), "amalgomated")
+	require.NoError(t, err)
+
+	goRunCmd := exec.Command("go", "run", ".")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, `stdout: "hello, options\n"
+panic: Working directory of command "tool" cannot be set
+panic: <nil>
+`, string(output))
+}
+
+// TestRunWorkspace verifies that amalgomate works in a workspace with multiple modules: the project module is the
+// workspace module that contains the output directory and main packages are resolved from the other workspace modules.
+func TestRunWorkspace(t *testing.T) {
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOWORK", "")
+
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "go.work",
+			Src:     "go 1.21\n\nuse (\n\t./project\n\t./tool\n)\n",
+		},
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/cmd/tool/main.go",
+			Src: `package main
+
+import (
+	"fmt"
+
+	"example.com/tool/internal/message"
+)
+
+func main() {
+	fmt.Println(message.Message)
+}
+`,
+		},
+		{
+			RelPath: "tool/internal/message/message.go",
+			Src:     "package message\n\nconst Message = \"workspace tool\"\n",
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+	outputDir := filepath.Join(projectDir, "amalgomated")
+	require.NoError(t, os.Mkdir(outputDir, 0755))
+
+	modInfo, err := moduleInfoForDirectory(outputDir)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/project", modInfo.Path)
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				MainPkg: "example.com/tool/cmd/tool",
+			},
+		},
+	}, outputDir, "main")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(outputDir, "internal", "example.com", "tool", "internal", "message", "message.go"))
+
+	goRunCmd := exec.Command("go", "run", "./amalgomated", "tool")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, "workspace tool", strings.TrimSpace(string(output)))
+}
+
+// TestRunGoVersionBuildConstraints verifies that a module that uses language features that are newer than the
+// language version of the output module can be amalgomated when Go version build constraints are enabled.
+func TestRunGoVersionBuildConstraints(t *testing.T) {
+	t.Setenv("GOTOOLCHAIN", "local")
+
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.22\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import "fmt"
+
+func main() {
+	// range over int requires Go 1.22
+	for i := range 3 {
+		fmt.Print(i)
+	}
+	fmt.Println()
+}
+`,
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+	outputDir := filepath.Join(projectDir, "amalgomated")
+
+	cfg := Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir: filepath.Join(tmpDir, "tool"),
+			},
+		},
+		GoVersionPolicy: GoVersionPolicyError,
+	}
+	err = Run(cfg, outputDir, "main")
+	assert.EqualError(t, err, "repackaged modules require a newer Go version than the host module:\n"+
+		"module for tool specifies go go1.22, which is newer than the version specified by the host module (go1.21)\n"+
+		"module for tool specifies toolchain go1.22, which is newer than the version specified by the host module (go1.21)")
+	// the output is not modified if the check fails
+	_, err = os.Stat(filepath.Join(outputDir, "internal"))
+	assert.True(t, os.IsNotExist(err), "repackaged modules should not be written: %v", err)
+
+	cfg.GoVersionPolicy = GoVersionPolicyWarn
+	cfg.GoVersionBuildConstraints = true
+	err = Run(cfg, outputDir, "main")
+	require.NoError(t, err)
+
+	repackagedMain, err := os.ReadFile(filepath.Join(outputDir, "internal", "example.com", "tool", "main.go"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(repackagedMain), "//go:build go1.22\n\n"), "repackaged file should start with build constraint:\n%s", string(repackagedMain))
+
+	goRunCmd := exec.Command("go", "run", "./amalgomated", "tool")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, "012", strings.TrimSpace(string(output)))
+}
+
+// TestRunTests verifies that the tests and testdata of a module are repackaged when CopyTests is true and that the
+// repackaged tests pass when run using RunTests.
+func TestRunTests(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import (
+	"flag"
+	"fmt"
+
+	"example.com/tool/internal/message"
+)
+
+var greeting = flag.String("greeting", "hello", "greeting")
+
+func main() {
+	flag.Parse()
+	fmt.Println(*greeting, message.Message())
+}
+`,
+		},
+		{
+			RelPath: "tool/main_test.go",
+			Src: `package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	os.Args = []string{"tool", "-greeting", "hi"}
+	main()
+}
+`,
+		},
+		{
+			RelPath: "tool/internal/message/message.go",
+			Src:     "package message\n\nfunc Message() string {\n\treturn \"world\"\n}\n",
+		},
+		{
+			RelPath: "tool/internal/message/message_test.go",
+			Src: `package message_test
+
+import (
+	"os"
+	"testing"
+
+	"example.com/tool/internal/message"
+)
+
+func TestMessage(t *testing.T) {
+	want, err := os.ReadFile("testdata/want.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := message.Message(); got != string(want) {
+		t.Errorf("got %q, want %q", got, string(want))
+	}
+}
+`,
+		},
+		{
+			RelPath: "tool/internal/message/testdata/want.txt",
+			Src:     "world",
+		},
+		{
+			RelPath: "other/go.mod",
+			Src:     "module example.com/other\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "other/main.go",
+			Src:     "package main\n\nfunc main() {}\n",
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+	outputDir := filepath.Join(projectDir, "amalgomated")
+
+	cfg := Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir:       filepath.Join(tmpDir, "tool"),
+				CopyTests: true,
+			},
+			"other": {
+				Dir: filepath.Join(tmpDir, "other"),
+			},
+		},
+	}
+	err = RunTests(cfg, outputDir, []string{"other"}, nil)
+	assert.EqualError(t, err, "program other does not copy tests: set copy-tests to true in its configuration and run amalgomate again")
+
+	err = Run(cfg, outputDir, "main")
+	require.NoError(t, err)
+	repackagedModuleDir := filepath.Join(outputDir, "internal", "example.com", "tool")
+	assert.FileExists(t, filepath.Join(repackagedModuleDir, "main_test.go"))
+	assert.FileExists(t, filepath.Join(repackagedModuleDir, "internal", "message", "testdata", "want.txt"))
+
+	err = RunTests(cfg, outputDir, nil, []string{"-count=1"})
+	require.NoError(t, err)
+}
+
+// TestRunRenameInternalEmbed verifies that files embedded from nested "internal" directories can be read by a program
+// that is amalgomated with RenameInternal.
+func TestRunRenameInternalEmbed(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "tool/go.mod",
+			Src:     "module example.com/tool\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "tool/main.go",
+			Src: `package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+
+	"example.com/tool/internal/pkg"
+)
+
+//go:embed internal/assets
+var assets embed.FS
+
+func main() {
+	sub, err := fs.Sub(assets, "internal/assets")
+	if err != nil {
+		panic(err)
+	}
+	greeting, err := fs.ReadFile(sub, "greeting.txt")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(greeting), pkg.Name())
+}
+`,
+		},
+		{
+			RelPath: "tool/internal/assets/greeting.txt",
+			Src:     "hello",
+		},
+		{
+			RelPath: "tool/internal/pkg/pkg.go",
+			Src: `package pkg
+
+import "embed"
+
+//go:embed internal/data/*.txt
+var data embed.FS
+
+func Name() string {
+	name, err := data.ReadFile("internal/data/name.txt")
+	if err != nil {
+		panic(err)
+	}
+	return string(name)
+}
+`,
+		},
+		{
+			RelPath: "tool/internal/pkg/internal/data/name.txt",
+			Src:     "world",
+		},
+		{
+			RelPath: "project/go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+	})
+	require.NoError(t, err)
+	projectDir := filepath.Join(tmpDir, "project")
+	outputDir := filepath.Join(projectDir, "amalgomated")
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool": {
+				Dir:            filepath.Join(tmpDir, "tool"),
+				RenameInternal: true,
+			},
+		},
+	}, outputDir, "main")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(outputDir, "internal", "example.com", "tool", "internal_", "pkg", "internal_", "data", "name.txt"))
+
+	goRunCmd := exec.Command("go", "run", "./amalgomated", "tool")
+	goRunCmd.Dir = projectDir
+	output, err := goRunCmd.CombinedOutput()
+	require.NoError(t, err, "Output: %s", string(output))
+	assert.Equal(t, "hello world", strings.TrimSpace(string(output)))
+}
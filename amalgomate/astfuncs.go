@@ -10,10 +10,13 @@ import (
 	"go/printer"
 	"go/token"
 	"io/ioutil"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -27,76 +30,330 @@ const (
 	internalDir        = "internal"
 )
 
+// sourceModules returns the modules that provide the main packages of the programs in the provided configuration,
+// resolved from outputDir in the same manner as repackage, without repackaging them. The RepackagedDir of the returned
+// modules is blank.
+func sourceModules(config Config, outputDir string) ([]repackagedModule, error) {
+	var modules []repackagedModule
+	for _, currConfigKey := range sortedKeys(config.Pkgs) {
+		mainPkg, resolveDir, err := resolveMainPkg(config.Pkgs[currConfigKey], outputDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve main package for %s", currConfigKey)
+		}
+		currMainPkgModule, err := moduleInfoForPackage(mainPkg, resolveDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to determine module for main package")
+		}
+		modules = append(modules, repackagedModule{
+			Program: currConfigKey,
+			Path:    currMainPkgModule.Path,
+			Dir:     currMainPkgModule.Dir,
+		})
+	}
+	return modules, nil
+}
+
 // repackage repackages the module for the main package specified in the provided configuration and writes the
 // repackaged files into the provided output directory. The repackaged files are placed into a directory called
 // "internal" that is created in the provided directory. This function assumes and verifies that the provided
 // "outputDir" is a directory that exists. The provided configuration is processed based on the natural ordering of the
-// name of the commands.
-func repackage(config Config, outputDir string) error {
+// name of the commands. Returns the modules that were repackaged.
+func repackage(config Config, outputDir string) ([]repackagedModule, error) {
 	amalgomateDirName := internalDir
 	if config.AmalgomateDir != "" {
 		amalgomateDirName = config.AmalgomateDir
 	}
 
 	if outputDirInfo, err := os.Stat(outputDir); err != nil {
-		return errors.Wrapf(err, "failed to stat output directory: %s", outputDir)
+		return nil, errors.Wrapf(err, "failed to stat output directory: %s", outputDir)
 	} else if !outputDirInfo.IsDir() {
-		return errors.Wrapf(err, "not a directory: %s", outputDir)
+		return nil, errors.Wrapf(err, "not a directory: %s", outputDir)
 	}
 
 	amalgomateDir := filepath.Join(outputDir, amalgomateDirName)
 	// remove output directory if it already exists
 	if err := os.RemoveAll(amalgomateDir); err != nil {
-		return errors.Wrapf(err, "failed to remove directory: %s", amalgomateDir)
+		return nil, errors.Wrapf(err, "failed to remove directory: %s", amalgomateDir)
 	}
 
 	if err := os.Mkdir(amalgomateDir, 0755); err != nil {
-		return errors.Wrapf(err, "failed to create directory: %s", amalgomateDir)
+		return nil, errors.Wrapf(err, "failed to create directory: %s", amalgomateDir)
 	}
 
 	projectModuleInfo, err := moduleInfoForDirectory(outputDir)
 	if err != nil {
-		return errors.Wrapf(err, "failed to determine module for directory %s", outputDir)
+		return nil, errors.Wrapf(err, "failed to determine module for directory %s", outputDir)
 	}
 
 	relPathFromModuleToOutputDir, err := relpathNormalizedPaths(projectModuleInfo.Dir, amalgomateDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var projectGoVersion string
+	if config.GoVersionBuildConstraints {
+		projectGoMod, err := readGoModFile(filepath.Join(projectModuleInfo.Dir, "go.mod"))
+		if err != nil {
+			return nil, err
+		}
+		projectGoVersion, _ = goModVersions(projectGoMod)
+	}
+
+	var modules []repackagedModule
 	for _, currConfigKey := range sortedKeys(config.Pkgs) {
 		currMainPkg := config.Pkgs[currConfigKey]
 
-		currMainPkgModule, err := moduleInfoForPackage(currMainPkg.MainPkg, outputDir)
+		mainPkg, resolveDir, err := resolveMainPkg(currMainPkg, outputDir)
 		if err != nil {
-			return errors.Wrapf(err, "failed to determine module for main package")
+			return nil, errors.Wrapf(err, "failed to resolve main package for %s", currConfigKey)
+		}
+
+		currMainPkgModule, err := moduleInfoForPackage(mainPkg, resolveDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to determine module for main package")
 		}
 
 		if currMainPkgModule.Path == projectModuleInfo.Path {
-			return errors.Errorf("module for package %s was reported as %s, which is the same as the project module: it is likely that this package is not part of a real module, and repackaging non-modules is not supported", currMainPkg.MainPkg, currMainPkgModule.Path)
+			return nil, errors.Errorf("module for package %s was reported as %s, which is the same as the project module: it is likely that this package is not part of a real module, and repackaging non-modules is not supported", mainPkg, currMainPkgModule.Path)
+		}
+
+		// modules with a pinned version are repackaged into a directory named after the version
+		repackagedRootDir := amalgomateDir
+		relPathFromModuleToRepackagedRootDir := relPathFromModuleToOutputDir
+		if currMainPkg.Version != "" {
+			repackagedRootDir = filepath.Join(amalgomateDir, currMainPkg.Version)
+			relPathFromModuleToRepackagedRootDir = path.Join(relPathFromModuleToOutputDir, currMainPkg.Version)
+			if err := os.MkdirAll(repackagedRootDir, 0755); err != nil {
+				return nil, errors.Wrapf(err, "failed to create directory: %s", repackagedRootDir)
+			}
 		}
 
 		if err := copyModuleRecursively(
 			currMainPkgModule.Path,
 			currMainPkgModule.Dir,
-			filepath.Join(projectModuleInfo.Dir, relPathFromModuleToOutputDir),
-			currMainPkg.RenameInternal,
+			filepath.Join(projectModuleInfo.Dir, relPathFromModuleToRepackagedRootDir),
+			copyOptions{
+				RenameInternal: currMainPkg.RenameInternal,
+				CopyTests:      currMainPkg.CopyTests,
+				LenientEmbed:   config.LenientEmbed,
+				Build: buildOptions{
+					Tags:      config.BuildTags,
+					Platforms: config.Platforms,
+				},
+			},
 		); err != nil {
-			return errors.Wrapf(err, "failed to copy module")
+			return nil, errors.Wrapf(err, "failed to copy module")
 		}
 		if err := rewriteImports(
-			amalgomateDir,
+			repackagedRootDir,
 			currMainPkgModule.Path,
-			path.Join(projectModuleInfo.Path, relPathFromModuleToOutputDir),
-			currMainPkg.DoNotRewriteFlagImport,
-			currMainPkg.RenameInternal,
+			path.Join(projectModuleInfo.Path, relPathFromModuleToRepackagedRootDir),
+			resolveDir,
+			rewriteOptions{
+				DoNotRewriteFlagImport: currMainPkg.DoNotRewriteFlagImport,
+				RenameInternal:         currMainPkg.RenameInternal,
+				BuildTags:              config.BuildTags,
+				StripGoGenerate:        !config.KeepGoGenerate,
+				PackageName:            currMainPkg.packageName(),
+				EntrypointName:         currMainPkg.entrypointName(),
+				CopyTests:              currMainPkg.CopyTests,
+			},
 		); err != nil {
-			return errors.Wrapf(err, "failed to rewrite imports for module %+v", currMainPkgModule)
+			return nil, errors.Wrapf(err, "failed to rewrite imports for module %+v", currMainPkgModule)
+		}
+		if config.GoVersionBuildConstraints {
+			if err := addGoVersionBuildConstraintsForModule(currMainPkgModule, filepath.Join(repackagedRootDir, currMainPkgModule.Path), projectGoVersion); err != nil {
+				return nil, errors.Wrapf(err, "failed to add Go version build constraints for module %+v", currMainPkgModule)
+			}
+		}
+		modules = append(modules, repackagedModule{
+			Program:       currConfigKey,
+			Path:          currMainPkgModule.Path,
+			Dir:           currMainPkgModule.Dir,
+			RepackagedDir: filepath.Join(repackagedRootDir, currMainPkgModule.Path),
+		})
+	}
+
+	if config.LazyInit {
+		// init functions are rewritten after all of the modules are repackaged because rewriting the imports of a
+		// module processes the files of all of the modules that were repackaged before it
+		if err := rewriteInitFunctionsForModules(config, modules, projectModuleInfo); err != nil {
+			return nil, err
+		}
+	}
+	if config.RedirectStdio {
+		if err := processModuleDirs(modules, projectModuleInfo, redirectFlagStdio, func(moduleDir repackagedModuleDir) error {
+			return redirectStdio(moduleDir.Dir, moduleDir.ImportPath, moduleDir.FlagImportPath, moduleDir.SkipDirs, config.BuildTags)
+		}); err != nil {
+			return nil, errors.Wrapf(err, "failed to redirect standard streams")
+		}
+	}
+	if config.RedirectEnv {
+		if err := processModuleDirs(modules, projectModuleInfo, nil, func(moduleDir repackagedModuleDir) error {
+			return redirectEnv(moduleDir.Dir, moduleDir.ImportPath, moduleDir.SkipDirs, config.BuildTags)
+		}); err != nil {
+			return nil, errors.Wrapf(err, "failed to redirect environment")
+		}
+	}
+	if config.RedirectWd {
+		if err := processModuleDirs(modules, projectModuleInfo, nil, func(moduleDir repackagedModuleDir) error {
+			return redirectWd(moduleDir.Dir, moduleDir.ImportPath, moduleDir.SkipDirs, config.BuildTags)
+		}); err != nil {
+			return nil, errors.Wrapf(err, "failed to redirect working directory")
+		}
+	}
+	if config.PropagateContext {
+		if err := processModuleDirs(modules, projectModuleInfo, nil, func(moduleDir repackagedModuleDir) error {
+			return redirectContext(moduleDir.Dir, moduleDir.ImportPath, moduleDir.SkipDirs, config.BuildTags)
+		}); err != nil {
+			return nil, errors.Wrapf(err, "failed to propagate context")
+		}
+	}
+	if config.ResetVariables {
+		// reset functions are added after the other rewrites so that the init functions that register them are not
+		// deferred and the variables of the packages written by amalgomate are also reset
+		if err := processModuleDirs(modules, projectModuleInfo, addFlagResetFunction, func(moduleDir repackagedModuleDir) error {
+			return addResetFunctions(moduleDir.Dir, moduleDir.ImportPath, moduleDir.FlagImportPath, config.RerunInits, moduleDir.SkipDirs, config.BuildTags)
+		}); err != nil {
+			return nil, errors.Wrapf(err, "failed to add reset functions")
+		}
+	}
+	if len(libraryModulePackages(config)) > 0 {
+		// the lock package is written last so that its state is not reset
+		if err := processModuleDirs(modules, projectModuleInfo, writeFlagLock, func(moduleDir repackagedModuleDir) error {
+			return writeLockPackage(moduleDir.Dir, moduleDir.FlagImportPath)
+		}); err != nil {
+			return nil, errors.Wrapf(err, "failed to write lock packages")
+		}
+	}
+	return modules, nil
+}
+
+// rewriteInitFunctionsForModules rewrites the init functions of each of the provided repackaged modules using
+// rewriteInitFunctions. The entrypoints of a module are those of all of the programs that were repackaged into the same
+// directory. projectModuleInfo is the module that contains the output directory.
+func rewriteInitFunctionsForModules(config Config, modules []repackagedModule, projectModuleInfo *GoModInfo) error {
+	entrypoints := make(map[string][]lazyInitEntrypoint)
+	for _, module := range modules {
+		pkg := config.Pkgs[module.Program]
+		entrypoint := lazyInitEntrypoint{Package: pkg.packageName(), Name: pkg.entrypointName()}
+		if !slices.Contains(entrypoints[module.RepackagedDir], entrypoint) {
+			entrypoints[module.RepackagedDir] = append(entrypoints[module.RepackagedDir], entrypoint)
+		}
+	}
+
+	repackagedDirs := repackagedModuleDirs(modules)
+	for _, repackagedDir := range repackagedDirs {
+		importPath, err := importPathForDir(projectModuleInfo, repackagedDir)
+		if err != nil {
+			return err
+		}
+		if err := rewriteInitFunctions(
+			repackagedDir,
+			importPath,
+			entrypoints[repackagedDir],
+			nestedDirs(repackagedDir, repackagedDirs),
+			config.BuildTags,
+		); err != nil {
+			return errors.Wrapf(err, "failed to rewrite init functions in %s", repackagedDir)
 		}
 	}
 	return nil
 }
 
+// repackagedModuleDir describes a directory into which one or more modules were repackaged.
+type repackagedModuleDir struct {
+	// Dir is the directory into which the modules were repackaged.
+	Dir string
+	// ImportPath is the import path of Dir.
+	ImportPath string
+	// FlagImportPath is the import path of the repackaged "flag" package used by the modules, or blank if the "flag"
+	// package was not repackaged.
+	FlagImportPath string
+	// SkipDirs are the directories within Dir into which other modules were repackaged.
+	SkipDirs []string
+}
+
+// processModuleDirs calls processModuleDir for each distinct directory into which the provided modules were
+// repackaged. If the "flag" package was repackaged alongside a module and processFlagDir is non-nil, processFlagDir is
+// first called once for its directory. projectModuleInfo is the module that contains the output directory.
+func processModuleDirs(modules []repackagedModule, projectModuleInfo *GoModInfo, processFlagDir func(flagDir string) error, processModuleDir func(moduleDir repackagedModuleDir) error) error {
+	repackagedDirs := repackagedModuleDirs(modules)
+	flagImportPaths := make(map[string]string)
+	processedDirs := make(map[string]bool)
+	for _, module := range modules {
+		if processedDirs[module.RepackagedDir] {
+			// module was repackaged into the same directory as a module that was already processed
+			continue
+		}
+		processedDirs[module.RepackagedDir] = true
+
+		// the "flag" package is repackaged into the directory that contains the repackaged module
+		flagDir := filepath.Join(strings.TrimSuffix(module.RepackagedDir, filepath.FromSlash(module.Path)), flagPackageDir)
+		flagImportPath, ok := flagImportPaths[flagDir]
+		if !ok {
+			if _, err := os.Stat(flagDir); err == nil {
+				if processFlagDir != nil {
+					if err := processFlagDir(flagDir); err != nil {
+						return errors.Wrapf(err, "failed to process %s", flagDir)
+					}
+				}
+				if flagImportPath, err = importPathForDir(projectModuleInfo, flagDir); err != nil {
+					return err
+				}
+			}
+			flagImportPaths[flagDir] = flagImportPath
+		}
+
+		importPath, err := importPathForDir(projectModuleInfo, module.RepackagedDir)
+		if err != nil {
+			return err
+		}
+		if err := processModuleDir(repackagedModuleDir{
+			Dir:            module.RepackagedDir,
+			ImportPath:     importPath,
+			FlagImportPath: flagImportPath,
+			SkipDirs:       nestedDirs(module.RepackagedDir, repackagedDirs),
+		}); err != nil {
+			return errors.Wrapf(err, "failed to process %s", module.RepackagedDir)
+		}
+	}
+	return nil
+}
+
+// repackagedModuleDirs returns the distinct directories into which the provided modules were repackaged in the order
+// in which they were repackaged.
+func repackagedModuleDirs(modules []repackagedModule) []string {
+	var dirs []string
+	for _, module := range modules {
+		if !slices.Contains(dirs, module.RepackagedDir) {
+			dirs = append(dirs, module.RepackagedDir)
+		}
+	}
+	return dirs
+}
+
+// nestedDirs returns the directories in dirs that are within dir. Modules whose module path has the path of another
+// module as a prefix are repackaged within the directory of that module.
+func nestedDirs(dir string, dirs []string) []string {
+	var nested []string
+	for _, currDir := range dirs {
+		if strings.HasPrefix(currDir, dir+string(filepath.Separator)) {
+			nested = append(nested, currDir)
+		}
+	}
+	return nested
+}
+
+// importPathForDir returns the import path of the provided directory, which must be within the provided module.
+func importPathForDir(moduleInfo *GoModInfo, dir string) (string, error) {
+	relPath, err := relpathNormalizedPaths(moduleInfo.Dir, dir)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(moduleInfo.Path, filepath.ToSlash(relPath)), nil
+}
+
 // removeEmptyDirs removes all directories in rootDir (including the root directory itself) that are empty or contain
 // only empty directories.
 func removeEmptyDirs(rootDir string) (removed bool, rErr error) {
@@ -168,23 +425,44 @@ func addImports(file *ast.File, fileSet *token.FileSet, outputDir string, config
 	processedPkgs := make(map[string]bool, len(config.Pkgs))
 	for _, name := range sortedKeys(config.Pkgs) {
 		progPkg := config.Pkgs[name]
+		if processedPkgs[progPkg.source()] {
+			// package was already imported using the name of the lexicographically first command that refers to it
+			continue
+		}
 
-		mainPkgInfo, err := packageForPatternInDirectory(progPkg.MainPkg, outputDir, packages.NeedName|packages.NeedFiles)
+		mainPkg, resolveDir, err := resolveMainPkg(progPkg, outputDir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve main package for %s", name)
+		}
+
+		mainPkgInfo, err := packageForPatternInDirectory(mainPkg, resolveDir, packages.NeedName|packages.NeedFiles|packages.NeedModule)
 		if err != nil {
 			return errors.Wrapf(err, "failed to get package information")
 		}
 
-		// repackaged import path is the project module import path + path to the output directory + amalgomateDirName + main package import path
+		// repackaged import path is the project module import path + path to the output directory + amalgomateDirName + version (if pinned) + main package import path
 		amalgomateDirName := internalDir
 		if config.AmalgomateDir != "" {
 			amalgomateDirName = config.AmalgomateDir
 		}
-		repackagedImportPath := path.Join(projectModule.Path, pathFromProjectModuleToOutputDir, amalgomateDirName, mainPkgInfo.PkgPath)
+		repackagedImportPath := path.Join(projectModule.Path, pathFromProjectModuleToOutputDir, amalgomateDirName, progPkg.Version, mainPkgInfo.PkgPath)
 		added := astutil.AddNamedImport(fileSet, file, name, repackagedImportPath)
 		if !added {
 			return errors.Errorf("failed to add import %s", repackagedImportPath)
 		}
-		processedPkgs[progPkg.MainPkg] = true
+		if file.Name.Name != "main" {
+			// the packages that support the library are written to the root of the repackaged module
+			for _, modulePkg := range libraryModulePackages(config) {
+				if mainPkgInfo.Module == nil {
+					return errors.Errorf("failed to determine module for package %s", mainPkgInfo.PkgPath)
+				}
+				modulePkgImportPath := path.Join(projectModule.Path, pathFromProjectModuleToOutputDir, amalgomateDirName, progPkg.Version, mainPkgInfo.Module.Path, modulePkg)
+				if !astutil.AddNamedImport(fileSet, file, modulePackageImportName(name, modulePkg), modulePkgImportPath) {
+					return errors.Errorf("failed to add import %s", modulePkgImportPath)
+				}
+			}
+		}
+		processedPkgs[progPkg.source()] = true
 	}
 	return nil
 }
@@ -230,26 +508,51 @@ func getFirstToken(file *ast.File, t token.Token) *ast.GenDecl {
 	return nil
 }
 
-func setVarCompositeLiteralElements(file *ast.File, constName string, elems []ast.Expr) error {
-	decl := getFirstToken(file, token.VAR)
-	if decl == nil {
-		return errors.Errorf("could not find token of type VAR in %s", file.Name)
-	}
-
-	var constExpr ast.Expr
-	for _, currSpec := range decl.Specs {
-		// declaration is already known to be of type const, so all of the specs are ValueSpec
-		valueSpec := currSpec.(*ast.ValueSpec)
-		for i, valueSpecName := range valueSpec.Names {
-			if valueSpecName.Name == constName {
-				constExpr = valueSpec.Values[i]
-				break
+// findVarValue returns the expression assigned to the package-level variable with the provided name in the provided
+// file. Returns an error if the file does not contain any variable declarations or if no variable with the provided
+// name is declared with a value.
+func findVarValue(file *ast.File, varName string) (ast.Expr, error) {
+	if getFirstToken(file, token.VAR) == nil {
+		return nil, errors.Errorf("could not find token of type VAR in %s", file.Name)
+	}
+
+	for _, currDecl := range file.Decls {
+		decl, ok := currDecl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.VAR {
+			continue
+		}
+		for _, currSpec := range decl.Specs {
+			// declaration is already known to be of type var, so all of the specs are ValueSpec
+			valueSpec := currSpec.(*ast.ValueSpec)
+			for i, valueSpecName := range valueSpec.Names {
+				if valueSpecName.Name == varName && i < len(valueSpec.Values) {
+					return valueSpec.Values[i], nil
+				}
 			}
 		}
 	}
+	return nil, errors.Errorf("could not find variable with name %s in given declaration", varName)
+}
 
-	if constExpr == nil {
-		return errors.Errorf("could not find variable with name %s in given declaration", constName)
+// setVarStringValue sets the value of the package-level string variable with the provided name to the provided value.
+func setVarStringValue(file *ast.File, varName, value string) error {
+	varExpr, err := findVarValue(file, varName)
+	if err != nil {
+		return err
+	}
+
+	basicLit, ok := varExpr.(*ast.BasicLit)
+	if !ok || basicLit.Kind != token.STRING {
+		return errors.Errorf("variable %s did not have a string literal value", varName)
+	}
+	basicLit.Value = strconv.Quote(value)
+	return nil
+}
+
+func setVarCompositeLiteralElements(file *ast.File, constName string, elems []ast.Expr) error {
+	constExpr, err := findVarValue(file, constName)
+	if err != nil {
+		return err
 	}
 
 	var compLit *ast.CompositeLit
@@ -264,27 +567,143 @@ func setVarCompositeLiteralElements(file *ast.File, constName string, elems []as
 }
 
 func createMapLiteralEntries(pkgs map[string]SrcPkg) []ast.Expr {
+	namedImports := programNamedImports(pkgs)
+	var entries []ast.Expr
+	for _, name := range slices.Sorted(maps.Keys(namedImports)) {
+		// programs that share a named import share repackaged code, so the entrypoint is that of the first command
+		entries = append(entries, createMapKeyValueExpression(name, namedImports[name], pkgs[namedImports[name]].entrypointName()))
+	}
+	return entries
+}
+
+// createModulesMapLiteralEntries creates map key value expressions of the form
+// "{{name}}": {{{field}}: {{namedImport}}_{{modulePkg}}.{{funcName}}, ...} for each of the programs and aliases in the
+// provided packages, with a field for each of the functions in libraryModuleFuncs whose package is in modulePkgs.
+func createModulesMapLiteralEntries(pkgs map[string]SrcPkg, modulePkgs []string) []ast.Expr {
+	namedImports := programNamedImports(pkgs)
+	var entries []ast.Expr
+	for _, name := range slices.Sorted(maps.Keys(namedImports)) {
+		var fields []ast.Expr
+		for _, moduleFunc := range libraryModuleFuncs {
+			if !slices.Contains(modulePkgs, moduleFunc.modulePkg) {
+				continue
+			}
+			fields = append(fields, &ast.KeyValueExpr{
+				Key: ast.NewIdent(moduleFunc.field),
+				Value: &ast.SelectorExpr{
+					X:   ast.NewIdent(modulePackageImportName(namedImports[name], moduleFunc.modulePkg)),
+					Sel: ast.NewIdent(moduleFunc.funcName),
+				},
+			})
+		}
+		entries = append(entries, &ast.KeyValueExpr{
+			Key: &ast.BasicLit{
+				Kind:  token.STRING,
+				Value: fmt.Sprintf(`"%v"`, name),
+			},
+			Value: &ast.CompositeLit{Elts: fields},
+		})
+	}
+	return entries
+}
+
+// programNamedImports returns a map from the name of every program and alias in the provided packages to the name of
+// the import of its repackaged main package.
+func programNamedImports(pkgs map[string]SrcPkg) map[string]string {
 	// if multiple commands refer to the same package, the command that is lexicographically first is the one that
 	// is used for the named import. Create a map that stores the mapping from the package to the import name.
 	pkgToFirstCmdMap := make(map[string]string, len(pkgs))
 	for _, name := range sortedKeys(pkgs) {
-		if _, ok := pkgToFirstCmdMap[pkgs[name].MainPkg]; !ok {
+		if _, ok := pkgToFirstCmdMap[pkgs[name].source()]; !ok {
 			// add mapping only if it does not already exist
-			pkgToFirstCmdMap[pkgs[name].MainPkg] = name
+			pkgToFirstCmdMap[pkgs[name].source()] = name
 		}
 	}
 
-	var entries []ast.Expr
+	// aliases refer to the named import of the package that declares them
+	namedImports := make(map[string]string, len(pkgs))
 	for _, name := range sortedKeys(pkgs) {
-		entries = append(entries, createMapKeyValueExpression(name, pkgToFirstCmdMap[pkgs[name].MainPkg]))
+		namedImports[name] = pkgToFirstCmdMap[pkgs[name].source()]
+		for _, alias := range pkgs[name].Aliases {
+			namedImports[alias] = namedImports[name]
+		}
+	}
+	return namedImports
+}
+
+// libraryModuleFuncs are the functions of the packages written to the root of every repackaged module that are called
+// by a generated library. field is the name of the field of the module type of the library that refers to the function
+// and enabled returns true if the package is written for the provided configuration. The package whose enabled
+// function is nil serializes the invocations of the programs of the module and is written if any other package is.
+var libraryModuleFuncs = []struct {
+	field     string
+	modulePkg string
+	funcName  string
+	enabled   func(config Config) bool
+}{
+	{field: "reset", modulePkg: resetPackage, funcName: "Reset", enabled: func(config Config) bool { return config.ResetVariables }},
+	{field: "redirect", modulePkg: stdioPackage, funcName: "Redirect", enabled: func(config Config) bool { return config.RedirectStdio }},
+	{field: "setEnv", modulePkg: envPackage, funcName: "Set", enabled: func(config Config) bool { return config.RedirectEnv }},
+	{field: "setDir", modulePkg: wdPackage, funcName: "Set", enabled: func(config Config) bool { return config.RedirectWd }},
+	{field: "setContext", modulePkg: contextPackage, funcName: "Set", enabled: func(config Config) bool { return config.PropagateContext }},
+	{field: "lock", modulePkg: lockPackage, funcName: "Lock"},
+}
+
+// libraryModulePackages returns the names of the packages written to the root of every repackaged module that are used
+// by a generated library with the provided configuration. If any are used, the package that serializes the invocations
+// of the programs of the module is used as well.
+func libraryModulePackages(config Config) []string {
+	var pkgs []string
+	for _, moduleFunc := range libraryModuleFuncs {
+		if moduleFunc.enabled != nil && moduleFunc.enabled(config) {
+			pkgs = append(pkgs, moduleFunc.modulePkg)
+		}
+	}
+	if len(pkgs) > 0 {
+		pkgs = append(pkgs, lockPackage)
+	}
+	return pkgs
+}
+
+// modulePackageImportName returns the name of the import of the package with the provided name that is written to the
+// root of the module of the main package imported with the provided name.
+func modulePackageImportName(namedImport, modulePkg string) string {
+	return namedImport + "_" + modulePkg
+}
+
+// hiddenProgramNames returns the sorted names of the programs that should not be reported as commands by the generated
+// source.
+func hiddenProgramNames(pkgs map[string]SrcPkg) []string {
+	var hidden []string
+	for _, name := range sortedKeys(pkgs) {
+		if pkgs[name].Hidden {
+			hidden = append(hidden, name)
+		}
+		hidden = append(hidden, pkgs[name].Aliases...)
+	}
+	sort.Strings(hidden)
+	return hidden
+}
+
+// createSetLiteralEntries creates map key value expressions of the form "{{name}}": true for each of the provided names.
+func createSetLiteralEntries(names []string) []ast.Expr {
+	var entries []ast.Expr
+	for _, name := range names {
+		entries = append(entries, &ast.KeyValueExpr{
+			Key: &ast.BasicLit{
+				Kind:  token.STRING,
+				Value: fmt.Sprintf(`"%v"`, name),
+			},
+			Value: ast.NewIdent("true"),
+		})
 	}
 	return entries
 }
 
-// createMapKeyValueExpression creates a new map key value function expression of the form "{{name}}": func() { {{namedImport}}.{{amalgomatedMain}}() }.
+// createMapKeyValueExpression creates a new map key value function expression of the form "{{name}}": func() { {{namedImport}}.{{entrypointName}}() }.
 // In most cases "name" and "namedImport" will be the same, but if multiple commands refer to the same package, then the
 // commands that are lexicographically later should refer to the named import of the first command.
-func createMapKeyValueExpression(name, namedImport string) *ast.KeyValueExpr {
+func createMapKeyValueExpression(name, namedImport, entrypointName string) *ast.KeyValueExpr {
 	return &ast.KeyValueExpr{
 		Key: &ast.BasicLit{
 			Kind:  token.STRING,
@@ -300,7 +719,7 @@ func createMapKeyValueExpression(name, namedImport string) *ast.KeyValueExpr {
 						X: &ast.CallExpr{
 							Fun: &ast.SelectorExpr{
 								X:   ast.NewIdent(namedImport),
-								Sel: ast.NewIdent(amalgomatedMain),
+								Sel: ast.NewIdent(entrypointName),
 							},
 						},
 					},
@@ -324,6 +743,63 @@ func renameFunction(fileNode *ast.File, originalName, newName string) error {
 	return nil
 }
 
+// renameFunctionCalls updates all calls in the provided file to the package-level function with the name originalName
+// to call newName instead. The file must have been parsed with object resolution: calls to local variables and
+// parameters that shadow the function are not renamed. Calls that are not resolved within the file are assumed to call
+// the function declared in another file of the package.
+func renameFunctionCalls(fileNode *ast.File, originalName, newName string) {
+	ast.Inspect(fileNode, func(n ast.Node) bool {
+		if callExpr, ok := n.(*ast.CallExpr); ok {
+			if ident, ok := callExpr.Fun.(*ast.Ident); ok && ident.Name == originalName && (ident.Obj == nil || ident.Obj.Kind == ast.Fun) {
+				ident.Name = newName
+			}
+		}
+		return true
+	})
+}
+
+// rewriteLinknameDirectives rewrites the targets of the "//go:linkname localname importpath.name" directives in the
+// provided file. repackagedImportPath is called with the import path of every target and returns the import path that
+// should replace it and true if the target should be rewritten. Returns true if any directive was rewritten.
+func rewriteLinknameDirectives(fileNode *ast.File, repackagedImportPath func(string) (string, bool, error)) (bool, error) {
+	updated := false
+	for _, cg := range fileNode.Comments {
+		for _, c := range cg.List {
+			fields := strings.Fields(c.Text)
+			if len(fields) != 3 || fields[0] != "//go:linkname" {
+				continue
+			}
+			importPath, name, ok := splitLinknameTarget(fields[2])
+			if !ok {
+				continue
+			}
+			newImportPath, ok, err := repackagedImportPath(importPath)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				continue
+			}
+			c.Text = strings.Join([]string{fields[0], fields[1], newImportPath + "." + name}, " ")
+			updated = true
+		}
+	}
+	return updated, nil
+}
+
+// splitLinknameTarget splits the target of a go:linkname directive into the import path and the name of the symbol.
+// The import path ends at the first "." after its last "/" because symbol names may contain "." (for example,
+// "github.com/foo/bar.(*T).Method").
+func splitLinknameTarget(target string) (importPath, name string, ok bool) {
+	lastSlash := strings.LastIndex(target, "/")
+	dot := strings.Index(target[lastSlash+1:], ".")
+	if dot == -1 {
+		return "", "", false
+	}
+	dot += lastSlash + 1
+	return target[:dot], target[dot+1:], true
+}
+
 func findFunction(fileNode *ast.File, funcName string) *ast.FuncDecl {
 	for _, currDecl := range fileNode.Decls {
 		switch t := currDecl.(type) {
@@ -352,9 +828,9 @@ func writeAstToFile(path string, fileNode *ast.File, fileSet *token.FileSet) (wr
 	return nil
 }
 
-func sortedKeys(pkgs map[string]SrcPkg) []string {
-	sortedKeys := make([]string, 0, len(pkgs))
-	for currKey := range pkgs {
+func sortedKeys[V any](m map[string]V) []string {
+	sortedKeys := make([]string, 0, len(m))
+	for currKey := range m {
 		sortedKeys = append(sortedKeys, currKey)
 	}
 	sort.Strings(sortedKeys)
@@ -0,0 +1,117 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/ast"
+	"go/build/constraint"
+	"slices"
+	"strings"
+)
+
+// maxFreeBuildTags is the maximum number of distinct platform tags in a build constraint for which all combinations
+// are evaluated. Constraints with more tags are assumed to be satisfiable.
+const maxFreeBuildTags = 12
+
+var (
+	// knownOS and knownArch are the values of GOOS and GOARCH recognized by the Go tool (see go/build/syslist.go).
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true, "hurd": true, "illumos": true,
+		"ios": true, "js": true, "linux": true, "netbsd": true, "openbsd": true, "plan9": true,
+		"solaris": true, "wasip1": true, "windows": true, "zos": true,
+	}
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "amd64p32": true, "arm": true, "armbe": true, "arm64": true, "arm64be": true,
+		"loong64": true, "mips": true, "mipsle": true, "mips64": true, "mips64le": true, "mips64p32": true,
+		"mips64p32le": true, "ppc": true, "ppc64": true, "ppc64le": true, "riscv": true, "riscv64": true, "s390": true,
+		"s390x": true, "sparc": true, "sparc64": true, "wasm": true,
+	}
+	// knownToolTags are tags that are set by the Go tool based on the build environment or build flags.
+	knownToolTags = map[string]bool{
+		"unix": true, "cgo": true, "gc": true, "gccgo": true, "race": true, "msan": true, "asan": true,
+	}
+)
+
+// excludedByBuildConstraints returns true if the build constraints of the provided file exclude it from every build.
+// A file is considered to be excluded if its "//go:build" (or "// +build") constraint cannot be satisfied for any
+// combination of the tags that are set by the Go tool (operating systems, architectures, Go versions, compilers and
+// build modes) when the provided build tags are set and all other tags are unset. For example, files with the
+// constraint "//go:build ignore" are excluded, while files with the constraint "//go:build linux && !cgo" are not.
+func excludedByBuildConstraints(fileNode *ast.File, buildTags []string) bool {
+	expr := buildConstraintExpr(fileNode)
+	if expr == nil {
+		return false
+	}
+	return !satisfiable(expr, isToolBuildTag, func(tag string) bool {
+		return slices.Contains(buildTags, tag)
+	})
+}
+
+// buildConstraintExpr returns the build constraint of the provided file or nil if the file does not have a build
+// constraint. If the file has a "//go:build" line, it is used; otherwise, the "// +build" lines are combined.
+func buildConstraintExpr(fileNode *ast.File) constraint.Expr {
+	var plusBuildExpr constraint.Expr
+	for _, cg := range fileNode.Comments {
+		if cg.Pos() > fileNode.Package {
+			break
+		}
+		for _, c := range cg.List {
+			switch {
+			case constraint.IsGoBuild(c.Text):
+				if expr, err := constraint.Parse(c.Text); err == nil {
+					return expr
+				}
+			case constraint.IsPlusBuild(c.Text):
+				expr, err := constraint.Parse(c.Text)
+				if err != nil {
+					continue
+				}
+				if plusBuildExpr == nil {
+					plusBuildExpr = expr
+				} else {
+					plusBuildExpr = &constraint.AndExpr{X: plusBuildExpr, Y: expr}
+				}
+			}
+		}
+	}
+	return plusBuildExpr
+}
+
+// satisfiable returns true if there is an assignment of the tags in expr for which it evaluates to true, where tags
+// for which isFree returns false are set if and only if isSet returns true for them.
+func satisfiable(expr constraint.Expr, isFree, isSet func(tag string) bool) bool {
+	var freeTags []string
+	seen := make(map[string]bool)
+	expr.Eval(func(tag string) bool {
+		if !seen[tag] && isFree(tag) {
+			freeTags = append(freeTags, tag)
+		}
+		seen[tag] = true
+		return false
+	})
+	if len(freeTags) > maxFreeBuildTags {
+		return true
+	}
+	for assignment := 0; assignment < 1<<len(freeTags); assignment++ {
+		set := make(map[string]bool, len(freeTags))
+		for i, tag := range freeTags {
+			set[tag] = assignment&(1<<i) != 0
+		}
+		if expr.Eval(func(tag string) bool {
+			if isFree(tag) {
+				return set[tag]
+			}
+			return isSet(tag)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// isToolBuildTag returns true if the provided tag may be set by the Go tool without being specified explicitly.
+func isToolBuildTag(tag string) bool {
+	return knownOS[tag] || knownArch[tag] || knownToolTags[tag] || strings.HasPrefix(tag, "go1.") || strings.HasPrefix(tag, "goexperiment.")
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_excludedByBuildConstraints(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		tags []string
+		want bool
+	}{
+		{
+			name: "no constraint",
+			src:  "package main\n",
+		},
+		{
+			name: "ignore",
+			src:  "//go:build ignore\n\npackage main\n",
+			want: true,
+		},
+		{
+			name: "ignore in +build line",
+			src:  "// +build ignore\n\npackage main\n",
+			want: true,
+		},
+		{
+			name: "platform constraint",
+			src:  "//go:build (linux || darwin) && !cgo && go1.21\n\npackage main\n",
+		},
+		{
+			name: "negated custom tag",
+			src:  "//go:build !tools\n\npackage main\n",
+		},
+		{
+			name: "unsatisfiable platform constraint",
+			src:  "//go:build linux && !linux\n\npackage main\n",
+			want: true,
+		},
+		{
+			name: "custom tag combined with platform",
+			src:  "//go:build tools && linux\n\npackage main\n",
+			want: true,
+		},
+		{
+			name: "custom tag that is set",
+			src:  "//go:build tools && linux\n\npackage main\n",
+			tags: []string{"tools"},
+		},
+		{
+			name: "negated custom tag that is set",
+			src:  "//go:build !tools\n\npackage main\n",
+			tags: []string{"tools"},
+			want: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fileNode, err := parser.ParseFile(token.NewFileSet(), "main.go", tc.src, parser.ParseComments)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, excludedByBuildConstraints(fileNode, tc.tags))
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/build/constraint"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/packages"
+)
+
+// buildOptions specifies the build tags and target platforms that are used when loading the packages of repackaged
+// modules.
+type buildOptions struct {
+	// Tags are the build tags that are set when loading packages.
+	Tags []string
+	// Platforms are the target platforms in "GOOS/GOARCH" form. If empty, packages are only loaded for the host
+	// platform.
+	Platforms []string
+}
+
+// loadConfigs returns a packages.Config for each of the target platforms that loads packages from the provided
+// directory using the provided mode and build tags. Returns a single configuration for the host platform if no
+// platforms are specified.
+func (o buildOptions) loadConfigs(dir string, mode packages.LoadMode) []*packages.Config {
+	var buildFlags []string
+	if len(o.Tags) > 0 {
+		buildFlags = []string{"-tags=" + strings.Join(o.Tags, ",")}
+	}
+	if len(o.Platforms) == 0 {
+		return []*packages.Config{{
+			Dir:        dir,
+			Env:        goEnvForDir(dir),
+			BuildFlags: buildFlags,
+			Mode:       mode,
+		}}
+	}
+
+	var loadConfigs []*packages.Config
+	for _, platform := range o.Platforms {
+		goos, goarch, _ := strings.Cut(platform, "/")
+		env := goEnvForDir(dir)
+		if env == nil {
+			env = os.Environ()
+		}
+		loadConfigs = append(loadConfigs, &packages.Config{
+			Dir:        dir,
+			Env:        append(env, "GOOS="+goos, "GOARCH="+goarch),
+			BuildFlags: buildFlags,
+			Mode:       mode,
+		})
+	}
+	return loadConfigs
+}
+
+// packagesForPatternInDirectoryForTargets returns the *packages.Package loaded for the provided pattern resolved in
+// the provided directory for each of the target platforms of the provided buildOptions, in the order in which the
+// platforms are specified. Returns an error if the package information cannot be loaded or if no packages are returned
+// for a platform. If multiple packages are loaded for a platform, the first one is used.
+func packagesForPatternInDirectoryForTargets(pattern, dir string, mode packages.LoadMode, opts buildOptions) ([]*packages.Package, error) {
+	var pkgs []*packages.Package
+	for _, loadConfig := range opts.loadConfigs(dir, mode) {
+		platformPkgs, err := packages.Load(loadConfig, pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to determine package for directory %s", dir)
+		}
+		if len(platformPkgs) == 0 {
+			return nil, errors.Errorf("no packages found in directory %s", dir)
+		}
+		pkgs = append(pkgs, platformPkgs[0])
+	}
+	return pkgs, nil
+}
+
+// excludedForTarget returns true if all of the Go files of the provided package were excluded by build constraints
+// when it was loaded, which means that the package is not built for the target platform of the load operation.
+func excludedForTarget(pkg *packages.Package) bool {
+	return len(pkg.GoFiles) == 0 && len(pkg.IgnoredFiles) > 0
+}
+
+// validateBuildTag returns an error if the provided value is not a valid build tag.
+func validateBuildTag(tag string) error {
+	if expr, err := constraint.Parse("//go:build " + tag); err != nil {
+		return errors.Errorf("build tag %q is not valid", tag)
+	} else if _, ok := expr.(*constraint.TagExpr); !ok {
+		return errors.Errorf("build tag %q is not valid", tag)
+	}
+	return nil
+}
+
+// validatePlatform returns an error if the provided value is not a platform of the form "GOOS/GOARCH" with an
+// operating system and architecture recognized by the Go tool.
+func validatePlatform(platform string) error {
+	goos, goarch, ok := strings.Cut(platform, "/")
+	if !ok || !knownOS[goos] || !knownArch[goarch] {
+		return errors.Errorf("platform %q must be of the form GOOS/GOARCH with a known operating system and architecture", platform)
+	}
+	return nil
+}
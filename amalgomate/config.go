@@ -7,34 +7,199 @@ package amalgomate
 import (
 	"go/token"
 	"maps"
-	"os"
 	"slices"
 
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v2"
+	"golang.org/x/mod/semver"
 )
 
 type Config struct {
-	Pkgs map[string]SrcPkg `yaml:"packages"`
+	Pkgs map[string]SrcPkg `yaml:"packages,omitempty" toml:"packages"`
+	// Include specifies the paths to configuration files that are merged into this configuration. Relative paths are
+	// resolved relative to the directory that contains the file that includes them. Included files are merged in
+	// order, so entries in later files override entries with the same name in earlier files, and the entries in this
+	// configuration override all included entries. Included files may themselves include other files. Include is
+	// resolved by LoadConfig and must be empty in any other context.
+	Include []string `yaml:"include,omitempty" toml:"include"`
+	// RemovePkgs specifies the names of packages from included configuration files that are removed from this
+	// configuration. Resolved by LoadConfig.
+	RemovePkgs []string `yaml:"remove-packages,omitempty" toml:"remove-packages"`
+	// Overlays specifies named sets of changes to Pkgs that can be applied to the merged configuration (for example,
+	// for a particular environment). Overlays defined in included files are merged by name. Selected overlays are
+	// applied by LoadConfig.
+	Overlays map[string]ConfigOverlay `yaml:"overlays,omitempty" toml:"overlays"`
 	// AmalgomateDir specifies the directory in the output path in which amalgomated packages are written.
 	// If blank, defaults to "internal". This directory should be considered fully managed by amalgomate, as it is
 	// removed before amalgomate is run. If non-empty, must be a valid Go identifier (token.IsIdentifier must return
 	// true for the value). This restriction is imposed to ensure that the value can be used as a valid import path and
 	// is safe to use as part of a path (it is not an absolute path, does not contain subdirectories or directives like
 	// "..", etc.).
-	AmalgomateDir string `yaml:"amalgomate-dir"`
+	AmalgomateDir string `yaml:"amalgomate-dir,omitempty" toml:"amalgomate-dir"`
 	// RepackageOnly specifies whether the amalgomate operation should only repackage target code. If true, does not
 	// write the top-level file that provides entrypoints to the amalgomated code.
-	RepackageOnly bool `yaml:"repackage-only"`
+	RepackageOnly bool `yaml:"repackage-only,omitempty" toml:"repackage-only"`
+	// DefaultProgram specifies the name of the program that is run by the generated source when no program is
+	// specified. If blank, a program must always be specified. If non-empty, must be the name or alias of one of the
+	// entries in Pkgs.
+	DefaultProgram string `yaml:"default-program,omitempty" toml:"default-program"`
+	// UpdateGoMod specifies whether the go.mod file of the module that contains the output directory should be updated
+	// to require the minimum versions of modules needed by the repackaged modules. If false, requirements that are
+	// missing or older than the versions required by the repackaged modules are only reported as warnings. Only
+	// requirements on modules used by the non-test code of the repackaged modules are considered. The go.sum file is
+	// not updated, so "go mod tidy" must be run afterwards.
+	UpdateGoMod bool `yaml:"update-go-mod,omitempty" toml:"update-go-mod"`
+	// GoVersionPolicy specifies how amalgomate handles repackaged modules whose go.mod file specifies a newer "go" or
+	// "toolchain" version than the go.mod file of the module that contains the output directory. Must be
+	// GoVersionPolicyWarn (the default if blank), which prints a warning, or GoVersionPolicyError, which fails the
+	// operation.
+	GoVersionPolicy string `yaml:"go-version-policy,omitempty" toml:"go-version-policy"`
+	// GoVersionBuildConstraints specifies whether a "//go:build go1.N" constraint is added to the repackaged files of
+	// modules whose "go" version is newer than the "go" version of the module that contains the output directory. The
+	// constraint preserves the language version of the repackaged module (which would otherwise be lost because go.mod
+	// files are not copied).
+	GoVersionBuildConstraints bool `yaml:"go-version-build-constraints,omitempty" toml:"go-version-build-constraints"`
+	// LenientEmbed specifies whether failures to resolve or copy the files referenced by the go:embed directives of
+	// repackaged packages should be printed as warnings rather than failing the operation.
+	LenientEmbed bool `yaml:"lenient-embed,omitempty" toml:"lenient-embed"`
+	// BuildTags specifies the build tags that are set when loading the packages of repackaged modules. Files that
+	// require these tags are considered when resolving go:embed patterns and determining which "main" files are
+	// excluded from every build.
+	BuildTags []string `yaml:"build-tags,omitempty" toml:"build-tags"`
+	// Platforms specifies the target platforms of the amalgomated program in "GOOS/GOARCH" form (for example,
+	// "linux/arm64"). The packages of repackaged modules are loaded for every platform, so the files embedded by
+	// platform-specific files are copied for all of the platforms. If empty, packages are only loaded for the host
+	// platform.
+	Platforms []string `yaml:"platforms,omitempty" toml:"platforms"`
+	// KeepGoGenerate specifies whether the "//go:generate" directives of repackaged files and the files that are only
+	// built with the "tools" build tag (such as "tools.go" files that track the dependencies of code generators) are
+	// kept. If false, the directives and files are removed so that running "go generate" in the output module does not
+	// run the generators of the repackaged modules.
+	KeepGoGenerate bool `yaml:"keep-go-generate,omitempty" toml:"keep-go-generate"`
+	// LazyInit specifies whether the init functions of repackaged modules are rewritten so that they run when their
+	// program is invoked rather than when the amalgomated program starts. The init functions of a module run once, in
+	// their original order, before the entrypoint of the first of its programs that is invoked. The initialization of
+	// package-level variables is not deferred, so initializers that read state established by init functions see the
+	// state before the init functions have run. Cannot be used with packages that specify CopyTests because repackaged
+	// tests do not call the entrypoint.
+	LazyInit bool `yaml:"lazy-init,omitempty" toml:"lazy-init"`
+	// ResetVariables specifies whether the package-level variables of repackaged modules are restored to the values of
+	// their initializers before each invocation of a program through the Run function of a generated library. Unless
+	// RerunInits is true, init functions are not run again, so the changes that they made to package-level variables
+	// are lost when the variables are restored. The variables of a package are restored in the order in which Go
+	// initializes them, which is determined from the references between the declarations of the package; if the order
+	// cannot be determined, the operation fails. Cannot be used with packages that specify DoNotRewriteFlagImport. Has
+	// no effect if the output package is "main".
+	ResetVariables bool `yaml:"reset-variables,omitempty" toml:"reset-variables"`
+	// RerunInits specifies whether the init functions of repackaged modules are run again after their package-level
+	// variables are restored, so that the state established by init functions (such as registered subcommands or flags
+	// defined in init functions) is also restored. Init functions that register state with packages outside of the
+	// repackaged module (such as sql.Register) panic or register duplicates when they are run again. Has no effect
+	// unless ResetVariables is true.
+	RerunInits bool `yaml:"rerun-inits,omitempty" toml:"rerun-inits"`
+	// RedirectStdio specifies whether the references to the standard streams of the "os" package in repackaged modules
+	// are rewritten so that the streams can be redirected using the WithIO option of a generated library. Has no effect
+	// if the output package is "main".
+	RedirectStdio bool `yaml:"redirect-stdio,omitempty" toml:"redirect-stdio"`
+	// RedirectEnv specifies whether the references to the functions of the "os" package that access the environment in
+	// repackaged modules are rewritten so that the environment of a program can be set using the WithEnv option of a
+	// generated library. The commands created by exec.Command and exec.CommandContext use the environment of the
+	// program unless their Env field is set. Has no effect if the output package is "main".
+	RedirectEnv bool `yaml:"redirect-env,omitempty" toml:"redirect-env"`
+	// RedirectWd specifies whether the references to the functions of the "os" and "path/filepath" packages that use
+	// the working directory in repackaged modules are rewritten so that the working directory of a program can be set
+	// using the WithDir option of a generated library. The commands created by exec.Command and exec.CommandContext are
+	// started in the working directory of the program unless their Dir field is set. Has no effect if the output package
+	// is "main".
+	RedirectWd bool `yaml:"redirect-wd,omitempty" toml:"redirect-wd"`
+	// PropagateContext specifies whether the references to context.Background, context.TODO and the functions of the
+	// "os/signal" package that register for signals in repackaged modules are rewritten so that a program can be run
+	// with a context that can be cancelled using the RunWithOptions function of a generated library. Has no effect if
+	// the output package is "main".
+	PropagateContext bool `yaml:"propagate-context,omitempty" toml:"propagate-context"`
+}
+
+// ConfigOverlay is a set of changes to the packages of a configuration.
+type ConfigOverlay struct {
+	// Pkgs specifies packages that are added by the overlay. An entry replaces any existing entry with the same name.
+	Pkgs map[string]SrcPkg `yaml:"packages,omitempty" toml:"packages"`
+	// RemovePkgs specifies the names of the packages that are removed by the overlay. Packages are removed before the
+	// entries in Pkgs are added.
+	RemovePkgs []string `yaml:"remove-packages,omitempty" toml:"remove-packages"`
 }
 
 type SrcPkg struct {
-	MainPkg                string   `yaml:"main"`
-	DoNotRewriteFlagImport []string `yaml:"do-not-rewrite-flag-import"`
-	RenameInternal         bool     `yaml:"rename-internal"`
+	MainPkg                string   `yaml:"main,omitempty" toml:"main"`
+	DoNotRewriteFlagImport []string `yaml:"do-not-rewrite-flag-import,omitempty" toml:"do-not-rewrite-flag-import"`
+	RenameInternal         bool     `yaml:"rename-internal,omitempty" toml:"rename-internal"`
+	// Aliases specifies additional names that can be used to invoke the program. Aliases are registered as programs
+	// in the generated source, but are not included in the list of commands that it reports. Aliases must be unique
+	// across all of the names and aliases in the configuration.
+	Aliases []string `yaml:"aliases,omitempty" toml:"aliases"`
+	// Hidden specifies whether the program should be omitted from the list of commands reported by the generated
+	// source. Hidden programs can still be invoked by name.
+	Hidden bool `yaml:"hidden,omitempty" toml:"hidden"`
+	// Version specifies the version of the module that provides MainPkg. If blank, the module is resolved in the same
+	// way that it would be from a Go file in the output directory. If non-empty, the specified version of the module
+	// is downloaded into a module cache that is private to amalgomate, so the module does not need to be a dependency
+	// of the output module. The repackaged module is written to a directory named after the version within the
+	// amalgomate directory so that multiple versions of the same module can be repackaged. If non-empty, must be a
+	// valid semantic version (which includes pseudo-versions).
+	Version string `yaml:"version,omitempty" toml:"version"`
+	// Dir specifies the directory of the main package as an alternative to MainPkg. The module path and root directory
+	// are determined from the module that contains the directory, so the module does not need to be a dependency of
+	// the output module. Relative paths are resolved relative to the directory that contains the configuration file
+	// when the configuration is loaded using LoadConfig and relative to the working directory otherwise. Cannot be
+	// specified with MainPkg or Version.
+	Dir string `yaml:"dir,omitempty" toml:"dir"`
+	// CopyTests specifies whether the "_test.go" files and "testdata" directories of the module should be repackaged
+	// along with the rest of the module. The imports of the test files are rewritten in the same manner as other files,
+	// and the repackaged tests can be run using RunTests.
+	CopyTests bool `yaml:"copy-tests,omitempty" toml:"copy-tests"`
+	// PackageName specifies the name to which "main" packages are renamed when the module is repackaged. If blank,
+	// defaults to "amalgomated". If non-empty, must be a valid Go identifier other than "main" or "_".
+	PackageName string `yaml:"package-name,omitempty" toml:"package-name"`
+	// EntrypointName specifies the name to which the "main" function of the program is renamed when the module is
+	// repackaged. The generated source invokes the program by calling this function. If blank, defaults to
+	// "AmalgomatedMain". If non-empty, must be an exported Go identifier. Specifying a different name is required if
+	// the main package already has a function with the default name (for example, if the program was itself created
+	// using amalgomate).
+	EntrypointName string `yaml:"entrypoint-name,omitempty" toml:"entrypoint-name"`
+}
+
+// source returns the string that identifies the main package of the program. Programs with the same source share the
+// same repackaged code.
+func (p SrcPkg) source() string {
+	switch {
+	case p.Dir != "":
+		return p.Dir
+	case p.Version != "":
+		return p.MainPkg + "@" + p.Version
+	default:
+		return p.MainPkg
+	}
+}
+
+// packageName returns the name to which the main packages of the program are renamed.
+func (p SrcPkg) packageName() string {
+	if p.PackageName != "" {
+		return p.PackageName
+	}
+	return amalgomatedPackage
+}
+
+// entrypointName returns the name to which the main function of the program is renamed.
+func (p SrcPkg) entrypointName() string {
+	if p.EntrypointName != "" {
+		return p.EntrypointName
+	}
+	return amalgomatedMain
 }
 
 func (cfg Config) Validate() error {
+	if len(cfg.Include) > 0 || len(cfg.RemovePkgs) > 0 {
+		return errors.Errorf("Include and RemovePkgs must be resolved using LoadConfig")
+	}
+
 	if cfg.AmalgomateDir != "" && !token.IsIdentifier(cfg.AmalgomateDir) {
 		return errors.Errorf("AmalgomateDir %s must be a valid Go identifier if it is non-empty", cfg.AmalgomateDir)
 	}
@@ -44,23 +209,116 @@ func (cfg Config) Validate() error {
 			return errors.Errorf("Pkgs cannot have an entry with an empty key")
 		}
 		pkg := cfg.Pkgs[name]
-		if pkg.MainPkg == "" {
+		if pkg.Dir != "" {
+			if pkg.MainPkg != "" || pkg.Version != "" {
+				return errors.Errorf("package %s in Pkgs specifies a directory, so it cannot also specify a main package or version", name)
+			}
+		} else if pkg.MainPkg == "" {
 			return errors.Errorf("package %s in Pkgs cannot have an empty main package directory", name)
 		}
+		if pkg.Version != "" && !semver.IsValid(pkg.Version) {
+			return errors.Errorf("package %s in Pkgs has version %s, which is not a valid semantic version", name, pkg.Version)
+		}
+		if pkg.PackageName != "" && (!token.IsIdentifier(pkg.PackageName) || pkg.PackageName == "main" || pkg.PackageName == "_") {
+			return errors.Errorf("package %s in Pkgs has package name %s, which must be a valid Go identifier other than main or _", name, pkg.PackageName)
+		}
+		if pkg.EntrypointName != "" && (!token.IsIdentifier(pkg.EntrypointName) || !token.IsExported(pkg.EntrypointName)) {
+			return errors.Errorf("package %s in Pkgs has entrypoint name %s, which must be an exported Go identifier", name, pkg.EntrypointName)
+		}
+		if cfg.LazyInit && pkg.CopyTests {
+			return errors.Errorf("package %s in Pkgs specifies CopyTests, which cannot be used with LazyInit because repackaged tests do not call the entrypoint that runs the init functions", name)
+		}
+		if cfg.ResetVariables && len(pkg.DoNotRewriteFlagImport) > 0 {
+			return errors.Errorf("package %s in Pkgs specifies DoNotRewriteFlagImport, which cannot be used with ResetVariables because the flags that its packages define on the flag.CommandLine of the host program would be defined again", name)
+		}
+	}
+
+	// programs with the same source share repackaged code, so they must use the same names and repackaging settings
+	firstNameForSource := make(map[string]string, len(cfg.Pkgs))
+	for _, name := range slices.Sorted(maps.Keys(cfg.Pkgs)) {
+		pkg := cfg.Pkgs[name]
+		firstName, ok := firstNameForSource[pkg.source()]
+		if !ok {
+			firstNameForSource[pkg.source()] = name
+			continue
+		}
+		firstPkg := cfg.Pkgs[firstName]
+		if pkg.packageName() != firstPkg.packageName() || pkg.entrypointName() != firstPkg.entrypointName() {
+			return errors.Errorf("package %s in Pkgs has the same main package as package %s, so it must have the same package name and entrypoint name", name, firstName)
+		}
+		if pkg.CopyTests != firstPkg.CopyTests || pkg.RenameInternal != firstPkg.RenameInternal {
+			return errors.Errorf("package %s in Pkgs has the same main package as package %s, so it must have the same copy-tests and rename-internal settings", name, firstName)
+		}
+	}
+
+	// names of all programs and aliases mapped to the program that declares them
+	declaredNames := make(map[string]string, len(cfg.Pkgs))
+	for name := range cfg.Pkgs {
+		declaredNames[name] = name
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Pkgs)) {
+		for _, alias := range cfg.Pkgs[name].Aliases {
+			if alias == "" {
+				return errors.Errorf("package %s in Pkgs cannot have an empty alias", name)
+			}
+			if declaredBy, ok := declaredNames[alias]; ok {
+				if declaredBy == alias {
+					return errors.Errorf("alias %s of package %s conflicts with the name of package %s", alias, name, declaredBy)
+				}
+				return errors.Errorf("alias %s of package %s conflicts with an alias of package %s", alias, name, declaredBy)
+			}
+			declaredNames[alias] = name
+		}
+	}
+
+	if cfg.DefaultProgram != "" {
+		if _, ok := declaredNames[cfg.DefaultProgram]; !ok {
+			return errors.Errorf("DefaultProgram %s must be the name or alias of an entry in Pkgs", cfg.DefaultProgram)
+		}
+	}
+
+	for _, tag := range cfg.BuildTags {
+		if err := validateBuildTag(tag); err != nil {
+			return errors.Wrapf(err, "BuildTags is not valid")
+		}
+	}
+	for _, platform := range cfg.Platforms {
+		if err := validatePlatform(platform); err != nil {
+			return errors.Wrapf(err, "Platforms is not valid")
+		}
+	}
+
+	switch cfg.GoVersionPolicy {
+	case "", GoVersionPolicyWarn, GoVersionPolicyError:
+	default:
+		return errors.Errorf("GoVersionPolicy must be %q or %q, was %q", GoVersionPolicyWarn, GoVersionPolicyError, cfg.GoVersionPolicy)
 	}
 	return nil
 }
 
-func LoadConfig(configPath string) (Config, error) {
-	file, err := os.ReadFile(configPath)
+// LoadConfig reads the configuration from the file at the provided path and validates it. The format of the file is
+// determined by its extension: ".json" files are read as JSON, ".toml" files are read as TOML and all other files are
+// read as YAML. Returns an error if the file contains keys that do not correspond to a configuration field.
+//
+// The returned configuration is the fully merged result: all of the files specified in Include are merged, the
+// packages in RemovePkgs are removed and the provided overlays are applied in order. The Include, RemovePkgs and
+// Overlays fields of the returned configuration are empty.
+func LoadConfig(configPath string, overlays ...string) (Config, error) {
+	cfg, _, file, err := loadConfigFile(configPath, nil)
 	if err != nil {
-		return Config{}, errors.Wrapf(err, "failed to read file %s", configPath)
+		return Config{}, err
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(file, &cfg); err != nil {
-		return Config{}, errors.Wrapf(err, "failed to unmarshal file %s", configPath)
+	for _, overlayName := range overlays {
+		overlay, ok := cfg.Overlays[overlayName]
+		if !ok {
+			return Config{}, errors.Errorf("overlay %s is not defined in configuration read from file %s (defined overlays: %v)", overlayName, configPath, slices.Sorted(maps.Keys(cfg.Overlays)))
+		}
+		if cfg, err = mergeConfig(cfg, Config{Pkgs: overlay.Pkgs, RemovePkgs: overlay.RemovePkgs}); err != nil {
+			return Config{}, errors.Wrapf(err, "failed to apply overlay %s", overlayName)
+		}
 	}
+	cfg.Overlays = nil
 
 	// impose restriction that configuration must specify at least 1 package
 	if len(cfg.Pkgs) == 0 {
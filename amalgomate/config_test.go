@@ -0,0 +1,536 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{
+			name: "valid aliases",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", Aliases: []string{"old-foo", "f"}},
+					"bar": {MainPkg: "github.com/bar", Aliases: []string{"b"}},
+				},
+			},
+		},
+		{
+			name: "empty alias",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", Aliases: []string{""}},
+				},
+			},
+			wantErr: "package foo in Pkgs cannot have an empty alias",
+		},
+		{
+			name: "alias conflicts with package name",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", Aliases: []string{"bar"}},
+					"bar": {MainPkg: "github.com/bar"},
+				},
+			},
+			wantErr: "alias bar of package foo conflicts with the name of package bar",
+		},
+		{
+			name: "alias conflicts with alias of other package",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", Aliases: []string{"x"}},
+					"bar": {MainPkg: "github.com/bar", Aliases: []string{"x"}},
+				},
+			},
+			wantErr: "alias x of package foo conflicts with an alias of package bar",
+		},
+		{
+			name: "duplicate alias within package",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", Aliases: []string{"x", "x"}},
+				},
+			},
+			wantErr: "alias x of package foo conflicts with an alias of package foo",
+		},
+		{
+			name: "invalid version",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", Version: "1.0.0"},
+				},
+			},
+			wantErr: "package foo in Pkgs has version 1.0.0, which is not a valid semantic version",
+		},
+		{
+			name: "directory with main package",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", Dir: "../foo"},
+				},
+			},
+			wantErr: "package foo in Pkgs specifies a directory, so it cannot also specify a main package or version",
+		},
+		{
+			name: "directory with version",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {Dir: "../foo", Version: "v1.0.0"},
+				},
+			},
+			wantErr: "package foo in Pkgs specifies a directory, so it cannot also specify a main package or version",
+		},
+		{
+			name: "invalid Go version policy",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo"},
+				},
+				GoVersionPolicy: "fail",
+			},
+			wantErr: `GoVersionPolicy must be "warn" or "error", was "fail"`,
+		},
+		{
+			name: "invalid package name",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", PackageName: "main"},
+				},
+			},
+			wantErr: "package foo in Pkgs has package name main, which must be a valid Go identifier other than main or _",
+		},
+		{
+			name: "unexported entrypoint name",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", EntrypointName: "fooMain"},
+				},
+			},
+			wantErr: "package foo in Pkgs has entrypoint name fooMain, which must be an exported Go identifier",
+		},
+		{
+			name: "different entrypoint names for same main package",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"bar": {MainPkg: "github.com/foo", EntrypointName: "FooMain"},
+					"foo": {MainPkg: "github.com/foo"},
+				},
+			},
+			wantErr: "package foo in Pkgs has the same main package as package bar, so it must have the same package name and entrypoint name",
+		},
+		{
+			name: "different copy tests settings for same main package",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"bar": {MainPkg: "github.com/foo", CopyTests: true},
+					"foo": {MainPkg: "github.com/foo"},
+				},
+			},
+			wantErr: "package foo in Pkgs has the same main package as package bar, so it must have the same copy-tests and rename-internal settings",
+		},
+		{
+			name: "different rename internal settings for same main package",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"bar": {MainPkg: "github.com/foo"},
+					"foo": {MainPkg: "github.com/foo", RenameInternal: true},
+				},
+			},
+			wantErr: "package foo in Pkgs has the same main package as package bar, so it must have the same copy-tests and rename-internal settings",
+		},
+		{
+			name: "invalid build tag",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo"},
+				},
+				BuildTags: []string{"foo,bar"},
+			},
+			wantErr: `BuildTags is not valid: build tag "foo,bar" is not valid`,
+		},
+		{
+			name: "invalid platform",
+			cfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo"},
+				},
+				Platforms: []string{"linux"},
+			},
+			wantErr: `Platforms is not valid: platform "linux" must be of the form GOOS/GOARCH with a known operating system and architecture`,
+		},
+		{
+			name: "default program can be an alias",
+			cfg: Config{
+				DefaultProgram: "f",
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", Aliases: []string{"f"}},
+				},
+			},
+		},
+		{
+			name: "default program must be declared",
+			cfg: Config{
+				DefaultProgram: "bar",
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo"},
+				},
+			},
+			wantErr: "DefaultProgram bar must be the name or alias of an entry in Pkgs",
+		},
+		{
+			name: "lazy init cannot be used with packages that copy tests",
+			cfg: Config{
+				LazyInit: true,
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", CopyTests: true},
+				},
+			},
+			wantErr: "package foo in Pkgs specifies CopyTests, which cannot be used with LazyInit because repackaged tests do not call the entrypoint that runs the init functions",
+		},
+		{
+			name: "reset variables cannot be used with packages that do not rewrite the flag import",
+			cfg: Config{
+				ResetVariables: true,
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", DoNotRewriteFlagImport: []string{"github.com/foo/cmd"}},
+				},
+			},
+			wantErr: "package foo in Pkgs specifies DoNotRewriteFlagImport, which cannot be used with ResetVariables because the flags that its packages define on the flag.CommandLine of the host program would be defined again",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.Validate()
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	wantCfg := Config{
+		AmalgomateDir: "amalgomated",
+		Pkgs: map[string]SrcPkg{
+			"foo": {
+				MainPkg:        "github.com/foo",
+				RenameInternal: true,
+				Aliases:        []string{"f"},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		fileName string
+		content  string
+		wantCfg  Config
+		wantErr  string
+	}{
+		{
+			name:     "YAML",
+			fileName: "config.yml",
+			content: `amalgomate-dir: amalgomated
+packages:
+  foo:
+    main: github.com/foo
+    rename-internal: true
+    aliases: [f]
+`,
+			wantCfg: wantCfg,
+		},
+		{
+			name:     "JSON",
+			fileName: "config.json",
+			content: `{
+	"amalgomate-dir": "amalgomated",
+	"packages": {
+		"foo": {
+			"main": "github.com/foo",
+			"rename-internal": true,
+			"aliases": ["f"]
+		}
+	}
+}
+`,
+			wantCfg: wantCfg,
+		},
+		{
+			name:     "TOML",
+			fileName: "config.toml",
+			content: `amalgomate-dir = "amalgomated"
+
+[packages.foo]
+main = "github.com/foo"
+rename-internal = true
+aliases = ["f"]
+`,
+			wantCfg: wantCfg,
+		},
+		{
+			name:     "YAML with unknown fields",
+			fileName: "config.yml",
+			content: `packages:
+  foo:
+    main: github.com/foo
+    rename-internals: true
+amalgomate-dirr: amalgomated
+unrelated: true
+`,
+			wantErr: `config.yml:4: unknown field "rename-internals" (did you mean "rename-internal"?)
+config.yml:5: unknown field "amalgomate-dirr" (did you mean "amalgomate-dir"?)
+config.yml:6: unknown field "unrelated"`,
+		},
+		{
+			name:     "JSON with unknown fields",
+			fileName: "config.json",
+			content: `{
+	"packages": {
+		"foo": {
+			"mian": "github.com/foo"
+		}
+	}
+}
+`,
+			wantErr: `config.json:4: unknown field "mian" (did you mean "main"?)`,
+		},
+		{
+			name:     "invalid JSON",
+			fileName: "config.json",
+			content: `{
+	"packages": {
+		"foo": {
+			"main": "github.com/foo",
+		}
+	}
+}
+`,
+			wantErr: `config.json:5: invalid JSON: invalid character '}' looking for beginning of object key string`,
+		},
+		{
+			name:     "TOML with unknown fields",
+			fileName: "config.toml",
+			content: `[packages.foo]
+main = "github.com/foo"
+rename-internals = true
+`,
+			wantErr: `config.toml:3: unknown field "rename-internals" (did you mean "rename-internal"?)`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			require.NoError(t, os.WriteFile(tc.fileName, []byte(tc.content), 0644))
+
+			gotCfg, err := LoadConfig(tc.fileName)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCfg, gotCfg)
+		})
+	}
+}
+
+// TestConfigJSONSchemaUpToDate verifies that the published JSON Schema matches the schema generated from the Config
+// type. Run "go generate" in this directory to update the published schema.
+func TestConfigJSONSchemaUpToDate(t *testing.T) {
+	wantSchema, err := ConfigJSONSchema()
+	require.NoError(t, err)
+
+	gotSchema, err := os.ReadFile(filepath.Join("..", "config.schema.json"))
+	require.NoError(t, err)
+
+	assert.Equal(t, string(wantSchema), string(gotSchema), `published schema is out of date: run "go generate" in the amalgomate directory`)
+}
+
+func TestLoadConfigIncludesAndOverlays(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		files    map[string]string
+		overlays []string
+		wantCfg  Config
+		wantErr  string
+	}{
+		{
+			name: "includes are merged in order and removals are applied",
+			files: map[string]string{
+				"common/base.yml": `amalgomate-dir: vendored
+packages:
+  foo:
+    main: github.com/foo
+  bar:
+    main: github.com/bar
+`,
+				"common/extra.json": `{"packages": {"bar": {"main": "github.com/other-bar"}, "baz": {"main": "github.com/baz"}}}`,
+				"config.yml": `include:
+  - common/base.yml
+  - common/extra.json
+remove-packages:
+  - baz
+packages:
+  foo:
+    main: github.com/foo
+    hidden: true
+`,
+			},
+			wantCfg: Config{
+				AmalgomateDir: "vendored",
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo", Hidden: true},
+					"bar": {MainPkg: "github.com/other-bar"},
+				},
+			},
+		},
+		{
+			name: "boolean fields explicitly set to false override included values",
+			files: map[string]string{
+				"common/base.yml": `repackage-only: true
+packages:
+  foo:
+    main: github.com/foo
+`,
+				"common/disable.toml": `repackage-only = false
+`,
+				"common/enable.json": `{"repackage-only": true}`,
+				"config.yml": `include:
+  - common/base.yml
+  - common/disable.toml
+  - common/enable.json
+`,
+			},
+			wantCfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo"},
+				},
+				RepackageOnly: true,
+			},
+		},
+		{
+			name: "boolean fields explicitly set to false by the including file override included values",
+			files: map[string]string{
+				"base.yml": `repackage-only: true
+packages:
+  foo:
+    main: github.com/foo
+`,
+				"config.yml": `include: [base.yml]
+repackage-only: false
+`,
+			},
+			wantCfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo"},
+				},
+			},
+		},
+		{
+			name: "boolean fields explicitly set to false by nested includes override included values",
+			files: map[string]string{
+				"base.yml": `repackage-only: true
+packages:
+  foo:
+    main: github.com/foo
+`,
+				"nested.yml": `include: [disable.toml]`,
+				"disable.toml": `repackage-only = false
+`,
+				"config.yml": `include: [base.yml, nested.yml]`,
+			},
+			wantCfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"foo": {MainPkg: "github.com/foo"},
+				},
+			},
+		},
+		{
+			name: "overlays are applied in order",
+			files: map[string]string{
+				"base.yml": `packages:
+  foo:
+    main: github.com/foo
+overlays:
+  ci:
+    packages:
+      bar:
+        main: github.com/bar
+`,
+				"config.yml": `include: [base.yml]
+packages:
+  baz:
+    main: github.com/baz
+overlays:
+  minimal:
+    remove-packages: [foo, bar]
+`,
+			},
+			overlays: []string{"ci", "minimal"},
+			wantCfg: Config{
+				Pkgs: map[string]SrcPkg{
+					"baz": {MainPkg: "github.com/baz"},
+				},
+			},
+		},
+		{
+			name: "undefined overlay",
+			files: map[string]string{
+				"config.yml": `packages:
+  foo:
+    main: github.com/foo
+`,
+			},
+			overlays: []string{"ci"},
+			wantErr:  "overlay ci is not defined in configuration read from file config.yml (defined overlays: [])",
+		},
+		{
+			name: "removing undefined package",
+			files: map[string]string{
+				"config.yml": `remove-packages: [bar]
+packages:
+  foo:
+    main: github.com/foo
+`,
+			},
+			wantErr: "failed to merge configuration file config.yml: cannot remove package bar because it is not defined",
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"a.yml":      `include: [config.yml]`,
+				"config.yml": `include: [a.yml]`,
+			},
+			wantErr: "configuration file config.yml includes itself",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			for name, content := range tc.files {
+				require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+				require.NoError(t, os.WriteFile(name, []byte(content), 0644))
+			}
+
+			gotCfg, err := LoadConfig("config.yml", tc.overlays...)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCfg, gotCfg)
+		})
+	}
+}
@@ -0,0 +1,408 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// loadConfigFile reads the configuration file at configPath and merges the configuration files that it includes into
+// it. Returns the merged configuration, the names of its boolean fields that are false because they were explicitly
+// set to false (by the file or by the files it includes) and the content of the file. includeStack contains the
+// absolute paths of the files that (transitively) include the file and is used to detect cycles. Overlays are merged,
+// but not applied.
+func loadConfigFile(configPath string, includeStack []string) (Config, []string, []byte, error) {
+	absConfigPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return Config{}, nil, nil, errors.Wrapf(err, "failed to convert %s into absolute path", configPath)
+	}
+	if slices.Contains(includeStack, absConfigPath) {
+		return Config{}, nil, nil, errors.Errorf("configuration file %s includes itself: %s", configPath, strings.Join(append(includeStack, absConfigPath), " -> "))
+	}
+	includeStack = append(includeStack, absConfigPath)
+
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return Config{}, nil, nil, errors.Wrapf(err, "failed to read file %s", configPath)
+	}
+
+	var cfg Config
+	if err := unmarshalConfigFile(configPath, file, &cfg); err != nil {
+		return Config{}, nil, nil, err
+	}
+	disabled, err := falseConfigFields(configPath, file)
+	if err != nil {
+		return Config{}, nil, nil, err
+	}
+
+	// resolve relative package directories relative to the directory of the configuration file that specifies them
+	resolvePkgDirs(cfg.Pkgs, filepath.Dir(absConfigPath))
+	for _, overlay := range cfg.Overlays {
+		resolvePkgDirs(overlay.Pkgs, filepath.Dir(absConfigPath))
+	}
+
+	var merged Config
+	var mergedDisabled []string
+	for _, includePath := range cfg.Include {
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(configPath), includePath)
+		}
+		includedCfg, includedDisabled, _, err := loadConfigFile(includePath, includeStack)
+		if err != nil {
+			return Config{}, nil, nil, errors.Wrapf(err, "failed to load configuration file included by %s", configPath)
+		}
+		if merged, err = mergeConfig(merged, includedCfg, includedDisabled...); err != nil {
+			return Config{}, nil, nil, errors.Wrapf(err, "failed to merge configuration file %s", includePath)
+		}
+		mergedDisabled = append(mergedDisabled, includedDisabled...)
+	}
+	if merged, err = mergeConfig(merged, cfg, disabled...); err != nil {
+		return Config{}, nil, nil, errors.Wrapf(err, "failed to merge configuration file %s", configPath)
+	}
+	// fields that were disabled by an included file and enabled by a later one are no longer disabled
+	mergedDisabled = slices.DeleteFunc(append(mergedDisabled, disabled...), func(name string) bool {
+		return boolConfigField(&merged, name).Bool()
+	})
+	return merged, mergedDisabled, file, nil
+}
+
+// falseConfigFields returns the names of the top-level keys of the provided content of the configuration file at
+// configPath that are explicitly set to false.
+func falseConfigFields(configPath string, content []byte) ([]string, error) {
+	var fields map[string]interface{}
+	var err error
+	if strings.ToLower(filepath.Ext(configPath)) == ".toml" {
+		err = toml.Unmarshal(content, &fields)
+	} else {
+		err = yaml.Unmarshal(content, &fields)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal file %s", configPath)
+	}
+	var names []string
+	for name, val := range fields {
+		if b, ok := val.(bool); ok && !b {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// boolConfigField returns the value of the boolean field of cfg whose configuration key has the provided name. Returns
+// the zero value if there is no such field.
+func boolConfigField(cfg *Config, name string) reflect.Value {
+	cfgVal := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < cfgVal.NumField(); i++ {
+		if cfgVal.Field(i).Kind() == reflect.Bool && configFieldName(cfgVal.Type().Field(i)) == name {
+			return cfgVal.Field(i)
+		}
+	}
+	return reflect.ValueOf(false)
+}
+
+// resolvePkgDirs updates the relative Dir values of the provided packages to be absolute paths resolved relative to
+// baseDir.
+func resolvePkgDirs(pkgs map[string]SrcPkg, baseDir string) {
+	for name, pkg := range pkgs {
+		if pkg.Dir != "" && !filepath.IsAbs(pkg.Dir) {
+			pkg.Dir = filepath.Join(baseDir, pkg.Dir)
+			pkgs[name] = pkg
+		}
+	}
+}
+
+// mergeConfig returns the result of merging the provided override configuration into the provided base configuration.
+// The packages in override.RemovePkgs are removed from the base packages, and then the packages in override.Pkgs are
+// added (replacing any existing entries with the same name). Non-empty values for other fields and overlays in the
+// override configuration replace the values in the base configuration. Boolean fields can only be set to true by the
+// override configuration, so disabledFields contains the configuration keys of the boolean fields that the override
+// configuration explicitly sets to false. Returns an error if override.RemovePkgs specifies a package that does not
+// exist in the base configuration. The Include and RemovePkgs fields of the returned configuration are empty.
+func mergeConfig(base, override Config, disabledFields ...string) (Config, error) {
+	merged := base
+	merged.Include = nil
+	merged.RemovePkgs = nil
+
+	merged.Pkgs = maps.Clone(base.Pkgs)
+	for _, name := range override.RemovePkgs {
+		if _, ok := merged.Pkgs[name]; !ok {
+			return Config{}, errors.Errorf("cannot remove package %s because it is not defined", name)
+		}
+		delete(merged.Pkgs, name)
+	}
+	for name, pkg := range override.Pkgs {
+		if merged.Pkgs == nil {
+			merged.Pkgs = make(map[string]SrcPkg)
+		}
+		merged.Pkgs[name] = pkg
+	}
+
+	merged.Overlays = maps.Clone(base.Overlays)
+	for name, overlay := range override.Overlays {
+		if merged.Overlays == nil {
+			merged.Overlays = make(map[string]ConfigOverlay)
+		}
+		merged.Overlays[name] = overlay
+	}
+
+	if override.AmalgomateDir != "" {
+		merged.AmalgomateDir = override.AmalgomateDir
+	}
+	if override.DefaultProgram != "" {
+		merged.DefaultProgram = override.DefaultProgram
+	}
+	if override.GoVersionPolicy != "" {
+		merged.GoVersionPolicy = override.GoVersionPolicy
+	}
+	if len(override.BuildTags) > 0 {
+		merged.BuildTags = override.BuildTags
+	}
+	if len(override.Platforms) > 0 {
+		merged.Platforms = override.Platforms
+	}
+	// boolean fields can only be set to true by the override configuration
+	mergedVal, overrideVal := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(override)
+	for i := 0; i < mergedVal.NumField(); i++ {
+		if field := mergedVal.Field(i); field.Kind() == reflect.Bool && field.CanSet() && overrideVal.Field(i).Bool() {
+			field.SetBool(true)
+		}
+	}
+	for _, name := range disabledFields {
+		if field := boolConfigField(&merged, name); field.CanSet() {
+			field.SetBool(false)
+		}
+	}
+	return merged, nil
+}
+
+// unmarshalConfigFile unmarshals the provided content of the configuration file at configPath into cfg. The format of
+// the content is determined by the extension of configPath: ".json" files are read as JSON, ".toml" files are read as
+// TOML and all other files are read as YAML. Returns an error if the content contains keys that do not correspond to a
+// configuration field. The error identifies the file and line of every such key and suggests the closest known key.
+func unmarshalConfigFile(configPath string, content []byte, cfg *Config) error {
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".json":
+		// YAML is a superset of JSON, so JSON is unmarshalled as YAML after verifying that it is valid JSON
+		var jsonVal interface{}
+		if err := json.Unmarshal(content, &jsonVal); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return errors.Errorf("%s:%d: invalid JSON: %v", configPath, lineForOffset(content, syntaxErr.Offset), err)
+			}
+			return errors.Wrapf(err, "%s: invalid JSON", configPath)
+		}
+		return unmarshalYAMLConfig(configPath, content, cfg)
+	case ".toml":
+		return unmarshalTOMLConfig(configPath, content, cfg)
+	default:
+		return unmarshalYAMLConfig(configPath, content, cfg)
+	}
+}
+
+// yamlUnknownFieldRegexp matches the messages of the errors returned by yaml.UnmarshalStrict for unknown fields.
+var yamlUnknownFieldRegexp = regexp.MustCompile(`^line (\d+): field (.+) not found in type (\S+)$`)
+
+func unmarshalYAMLConfig(configPath string, content []byte, cfg *Config) error {
+	err := yaml.UnmarshalStrict(content, cfg)
+	if err == nil {
+		return nil
+	}
+
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return errors.Wrapf(err, "failed to unmarshal file %s", configPath)
+	}
+
+	structTypes := configStructTypes()
+	var msgs []string
+	for _, currErr := range typeErr.Errors {
+		match := yamlUnknownFieldRegexp.FindStringSubmatch(currErr)
+		if match == nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", configPath, currErr))
+			continue
+		}
+		line, _ := strconv.Atoi(match[1])
+		msgs = append(msgs, unknownFieldMessage(configPath, line, match[2], structTypes[match[3]]))
+	}
+	return errors.New(strings.Join(msgs, "\n"))
+}
+
+func unmarshalTOMLConfig(configPath string, content []byte, cfg *Config) error {
+	decoder := toml.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(cfg)
+	if err == nil {
+		return nil
+	}
+
+	var strictErr *toml.StrictMissingError
+	if errors.As(err, &strictErr) {
+		var msgs []string
+		for _, currErr := range strictErr.Errors {
+			line, _ := currErr.Position()
+			key := currErr.Key()
+			if len(key) == 0 {
+				msgs = append(msgs, fmt.Sprintf("%s:%d: %v", configPath, line, currErr.Error()))
+				continue
+			}
+			parentType := typeForKeyPath(reflect.TypeOf(Config{}), key[:len(key)-1])
+			msgs = append(msgs, unknownFieldMessage(configPath, line, key[len(key)-1], parentType))
+		}
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		line, _ := decodeErr.Position()
+		return errors.Errorf("%s:%d: %v", configPath, line, decodeErr.Error())
+	}
+	return errors.Wrapf(err, "failed to unmarshal file %s", configPath)
+}
+
+// unknownFieldMessage returns the message that describes an unknown field with the provided name at the provided line
+// of the configuration file. If structType is a struct that has a known field with a name similar to the unknown
+// field, the message includes the name of that field as a suggestion.
+func unknownFieldMessage(configPath string, line int, field string, structType reflect.Type) string {
+	msg := fmt.Sprintf("%s:%d: unknown field %q", configPath, line, field)
+	if structType != nil && structType.Kind() == reflect.Struct {
+		if suggestion := closestString(field, configFieldNames(structType)); suggestion != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+	}
+	return msg
+}
+
+// configFieldNames returns the names of the configuration keys for the fields of the provided struct type.
+func configFieldNames(structType reflect.Type) []string {
+	var names []string
+	for i := 0; i < structType.NumField(); i++ {
+		if name := configFieldName(structType.Field(i)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// configFieldName returns the name of the configuration key for the provided field, which is the name specified in
+// its "yaml" tag. Returns an empty string if the field is not part of the configuration.
+func configFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// configStructTypes returns all of the struct types that are reachable from the Config type keyed by the name used
+// for them in error messages (for example, "amalgomate.SrcPkg").
+func configStructTypes() map[string]reflect.Type {
+	structTypes := make(map[string]reflect.Type)
+	var visit func(t reflect.Type)
+	visit = func(t reflect.Type) {
+		switch t.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Pointer:
+			visit(t.Elem())
+		case reflect.Struct:
+			if _, ok := structTypes[t.String()]; ok {
+				return
+			}
+			structTypes[t.String()] = t
+			for i := 0; i < t.NumField(); i++ {
+				visit(t.Field(i).Type)
+			}
+		}
+	}
+	visit(reflect.TypeOf(Config{}))
+	return structTypes
+}
+
+// typeForKeyPath returns the type of the value at the provided key path relative to a value of type t. Returns nil if
+// the path does not resolve to a value.
+func typeForKeyPath(t reflect.Type, keyPath []string) reflect.Type {
+	for _, key := range keyPath {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Map, reflect.Slice:
+			t = t.Elem()
+		case reflect.Struct:
+			var fieldType reflect.Type
+			for i := 0; i < t.NumField(); i++ {
+				if configFieldName(t.Field(i)) == key {
+					fieldType = t.Field(i).Type
+					break
+				}
+			}
+			if fieldType == nil {
+				return nil
+			}
+			t = fieldType
+		default:
+			return nil
+		}
+	}
+	return t
+}
+
+// closestString returns the candidate that is closest to the provided string if it is close enough to plausibly be
+// what was intended. Returns an empty string if there is no such candidate.
+func closestString(s string, candidates []string) string {
+	maxDistance := max(2, len(s)/3)
+	closest := ""
+	closestDistance := maxDistance + 1
+	for _, candidate := range candidates {
+		if distance := levenshteinDistance(s, candidate); distance < closestDistance {
+			closest = candidate
+			closestDistance = distance
+		}
+	}
+	return closest
+}
+
+// levenshteinDistance returns the minimum number of single-byte insertions, deletions and substitutions required to
+// change a into b.
+func levenshteinDistance(a, b string) int {
+	prevRow := make([]int, len(b)+1)
+	currRow := make([]int, len(b)+1)
+	for j := range prevRow {
+		prevRow[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		currRow[0] = i
+		for j := 1; j <= len(b); j++ {
+			substitutionCost := 1
+			if a[i-1] == b[j-1] {
+				substitutionCost = 0
+			}
+			currRow[j] = min(prevRow[j]+1, currRow[j-1]+1, prevRow[j-1]+substitutionCost)
+		}
+		prevRow, currRow = currRow, prevRow
+	}
+	return prevRow[len(b)]
+}
+
+// lineForOffset returns the 1-based line number of the byte at the provided offset in content.
+func lineForOffset(content []byte, offset int64) int {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/ast"
+	"path"
+	"path/filepath"
+)
+
+// contextPackage is the name of the package (and of its directory in the repackaged module) that provides the context
+// of an invocation to the code of a repackaged module.
+const contextPackage = "amalgomated_context"
+
+const contextPackageSrc = `// Code generated by amalgomate; DO NOT EDIT.
+
+// Package amalgomated_context provides the context of an invocation to the code of a repackaged module in place of
+// context.Background and context.TODO, and delivers the interrupt and termination signals for which the module
+// registers to the module when the context is cancelled as well as when the process receives them.
+package amalgomated_context
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// cancelSignals are the signals that are delivered when the context is cancelled, in order of preference.
+var cancelSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+var (
+	mu sync.RWMutex
+	// ctx is the context of the current invocation, or nil if the module is not run with a context.
+	ctx context.Context
+	// registrations maps the channels that are registered for cancel signals while a context that can be cancelled is
+	// set to channels that are closed when the registration is stopped.
+	registrations = make(map[chan<- os.Signal]chan struct{})
+)
+
+// Set sets the context of the module to the provided context and returns a function that restores the previous
+// context and stops the signal registrations for cancel signals made while the context was set. A nil context is
+// treated as context.Background().
+func Set(runCtx context.Context) (restore func()) {
+	if runCtx == nil {
+		runCtx = context.Background()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	prevCtx := ctx
+	ctx = runCtx
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for c, stop := range registrations {
+			close(stop)
+			delete(registrations, c)
+			signal.Stop(c)
+		}
+		ctx = prevCtx
+	}
+}
+
+func Background() context.Context {
+	mu.RLock()
+	defer mu.RUnlock()
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+func TODO() context.Context {
+	mu.RLock()
+	defer mu.RUnlock()
+	if ctx == nil {
+		return context.TODO()
+	}
+	return ctx
+}
+
+// Notify behaves like signal.Notify, except that if the module is run with a context that can be cancelled, the first
+// of the requested cancel signals (or os.Interrupt if no signals are provided) is also sent to c when the context is
+// cancelled.
+func Notify(c chan<- os.Signal, sig ...os.Signal) {
+	signal.Notify(c, sig...)
+	mu.Lock()
+	defer mu.Unlock()
+	if ctx == nil || ctx.Done() == nil {
+		return
+	}
+
+	var cancelSig os.Signal
+	if len(sig) == 0 {
+		cancelSig = os.Interrupt
+	}
+	for _, s := range sig {
+		if isCancelSignal(s) && (cancelSig == nil || s == os.Interrupt) {
+			cancelSig = s
+		}
+	}
+	if cancelSig == nil {
+		return
+	}
+
+	if stop, ok := registrations[c]; ok {
+		close(stop)
+	}
+	stop := make(chan struct{})
+	registrations[c] = stop
+	go func(done <-chan struct{}) {
+		select {
+		case <-done:
+			// as with the signal package, the signal is not sent if the channel is not ready to receive it
+			select {
+			case c <- cancelSig:
+			default:
+			}
+		case <-stop:
+		}
+	}(ctx.Done())
+}
+
+func Stop(c chan<- os.Signal) {
+	mu.Lock()
+	defer mu.Unlock()
+	if stop, ok := registrations[c]; ok {
+		close(stop)
+		delete(registrations, c)
+	}
+	signal.Stop(c)
+}
+
+func NotifyContext(parent context.Context, signals ...os.Signal) (context.Context, context.CancelFunc) {
+	mu.RLock()
+	cancellable := ctx != nil && ctx.Done() != nil
+	mu.RUnlock()
+	if !cancellable {
+		return signal.NotifyContext(parent, signals...)
+	}
+
+	notifyCtx, cancel := context.WithCancel(parent)
+	c := make(chan os.Signal, 1)
+	Notify(c, signals...)
+	go func() {
+		select {
+		case <-c:
+			cancel()
+		case <-notifyCtx.Done():
+		}
+	}()
+	return notifyCtx, func() {
+		Stop(c)
+		cancel()
+	}
+}
+
+func isCancelSignal(s os.Signal) bool {
+	for _, cancelSignal := range cancelSignals {
+		if s == cancelSignal {
+			return true
+		}
+	}
+	return false
+}
+`
+
+// contextFuncs maps the import paths of the packages whose functions are replaced by the functions of the package
+// written by redirectContext to the names of those functions.
+var contextFuncs = map[string][]string{
+	"context": {
+		"Background",
+		"TODO",
+	},
+	"os/signal": {
+		"Notify",
+		"NotifyContext",
+		"Stop",
+	},
+}
+
+// redirectContext rewrites the repackaged module in repackagedModuleDir so that it can be run with a context.
+// importPath is the import path of repackagedModuleDir.
+//
+// A package named amalgomated_context is written to repackagedModuleDir. In the non-test Go files of the module,
+// references to context.Background, context.TODO, signal.Notify, signal.NotifyContext and signal.Stop are replaced with
+// references to the corresponding functions of that package.
+func redirectContext(repackagedModuleDir, importPath string, skipDirs, buildTags []string) error {
+	contextDir := filepath.Join(repackagedModuleDir, contextPackage)
+	if err := writeModulePackage(contextDir, []byte(contextPackageSrc)); err != nil {
+		return err
+	}
+	contextImportPath := path.Join(importPath, contextPackage)
+
+	return rewriteModuleFiles(repackagedModuleDir, skipDirs, buildTags, func(fpath string) error {
+		return redirectContextInFile(fpath, contextImportPath)
+	})
+}
+
+// redirectContextInFile performs the rewrite described in redirectContext for the provided file. contextImportPath is
+// the import path of the package written by redirectContext.
+func redirectContextInFile(fpath, contextImportPath string) error {
+	return rewriteFile(fpath, contextPackage, contextImportPath, func(fileNode *ast.File) bool {
+		return replacePackageFuncs(fileNode, contextFuncs, contextPackage)
+	})
+}
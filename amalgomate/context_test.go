@@ -0,0 +1,86 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import "testing"
+
+func Test_redirectContextInFile(t *testing.T) {
+	runRewriteFileTests(t, []rewriteFileTestCase{
+		{
+			name: "rewrites context and signal functions",
+			src: `package foo
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func F() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	defer signal.Stop(c)
+	signal.Ignore(os.Kill)
+	_ = ctx
+}
+`,
+			want: `package foo
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+	amalgomated_context "github.com/project/internal/github.com/tool/amalgomated_context"
+)
+
+func F() {
+	ctx, cancel := context.WithTimeout(amalgomated_context.Background(), time.Second)
+	defer cancel()
+	c := make(chan os.Signal, 1)
+	amalgomated_context.Notify(c, os.Interrupt)
+	defer amalgomated_context.Stop(c)
+	signal.Ignore(os.Kill)
+	_ = ctx
+}
+`,
+		},
+		{
+			name: "removes unused imports",
+			src: `package foo
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+func F() {
+	ctx, stop := signal.NotifyContext(context.TODO(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+}
+`,
+			want: `package foo
+
+import (
+	"os"
+	amalgomated_context "github.com/project/internal/github.com/tool/amalgomated_context"
+)
+
+func F() {
+	ctx, stop := amalgomated_context.NotifyContext(amalgomated_context.TODO(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+}
+`,
+		},
+	}, func(fpath string) error {
+		return redirectContextInFile(fpath, "github.com/project/internal/github.com/tool/amalgomated_context")
+	})
+}
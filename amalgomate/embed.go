@@ -0,0 +1,218 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const goEmbedDirectivePrefix = "//go:embed"
+
+// rewriteEmbedPathsForRenamedInternal updates the go:embed directives and the embed.FS paths in the Go files in
+// repackagedModuleDir so that they refer to "internal_" rather than "internal" directories. This is required when
+// "internal" directories are renamed to be "internal_" when the module is copied because go:embed patterns and the
+// paths used to access files in an embed.FS are relative to the package directory and may name "internal"
+// directories.
+//
+// Every path element of a go:embed pattern that is exactly "internal" is renamed. A string literal is considered to be
+// an embed.FS path if it is a valid embed.FS path that names an "internal" directory and that is equal to or is
+// contained in the non-wildcard prefix of one of the go:embed patterns of its package. Import paths are never
+// modified.
+func rewriteEmbedPathsForRenamedInternal(repackagedModuleDir string) error {
+	return filepath.WalkDir(repackagedModuleDir, func(dirPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == "testdata" {
+			return fs.SkipDir
+		}
+		return rewriteEmbedPathsInPackageDir(dirPath)
+	})
+}
+
+// rewriteEmbedPathsInPackageDir performs the rewrite described in rewriteEmbedPathsForRenamedInternal for the Go files
+// in the provided directory. The files in a directory are processed together because the go:embed patterns of a
+// package may be declared in a different file than the one that accesses the embedded files.
+func rewriteEmbedPathsInPackageDir(dir string) error {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list directory %s", dir)
+	}
+
+	fileSet := token.NewFileSet()
+	files := make(map[string]*ast.File)
+	var embedPatterns []string
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".go") {
+			continue
+		}
+		fpath := filepath.Join(dir, dirEntry.Name())
+		fileNode, err := parser.ParseFile(fileSet, fpath, nil, parser.ParseComments)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse file %s", fpath)
+		}
+		files[fpath] = fileNode
+		for _, cg := range fileNode.Comments {
+			for _, c := range cg.List {
+				if patterns, ok := parseGoEmbedDirective(c.Text); ok {
+					embedPatterns = append(embedPatterns, patterns...)
+				}
+			}
+		}
+	}
+	if len(embedPatterns) == 0 {
+		return nil
+	}
+
+	for fpath, fileNode := range files {
+		updated := false
+		for _, cg := range fileNode.Comments {
+			for _, c := range cg.List {
+				patterns, ok := parseGoEmbedDirective(c.Text)
+				if !ok {
+					continue
+				}
+				changed := false
+				var quotedPatterns []string
+				for _, pattern := range patterns {
+					renamed := renameInternalPathElements(pattern)
+					changed = changed || renamed != pattern
+					quotedPatterns = append(quotedPatterns, quoteGoEmbedPattern(renamed))
+				}
+				if changed {
+					c.Text = goEmbedDirectivePrefix + " " + strings.Join(quotedPatterns, " ")
+					updated = true
+				}
+			}
+		}
+
+		importLits := make(map[*ast.BasicLit]bool)
+		for _, importSpec := range fileNode.Imports {
+			importLits[importSpec.Path] = true
+		}
+		ast.Inspect(fileNode, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING || importLits[lit] {
+				return true
+			}
+			val, err := strconv.Unquote(lit.Value)
+			if err != nil || !isEmbedFSPath(val, embedPatterns) {
+				return true
+			}
+			if renamed := renameInternalPathElements(val); renamed != val {
+				lit.Value = strconv.Quote(renamed)
+				updated = true
+			}
+			return true
+		})
+
+		if !updated {
+			continue
+		}
+		if err := writeAstToFile(fpath, fileNode, fileSet); err != nil {
+			return errors.Wrapf(err, "failed to write rewritten file %s", fpath)
+		}
+	}
+	return nil
+}
+
+// parseGoEmbedDirective returns the patterns of the provided comment if it is a go:embed directive. Patterns may be
+// unquoted or quoted using Go string or raw string syntax. Returns false if the comment is not a go:embed directive or
+// if it cannot be parsed.
+func parseGoEmbedDirective(text string) ([]string, bool) {
+	rest, ok := strings.CutPrefix(text, goEmbedDirectivePrefix)
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return nil, false
+	}
+	var patterns []string
+	for rest = strings.TrimLeft(rest, " \t"); rest != ""; rest = strings.TrimLeft(rest, " \t") {
+		var pattern string
+		switch rest[0] {
+		case '"', '`':
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, false
+			}
+			if pattern, err = strconv.Unquote(quoted); err != nil {
+				return nil, false
+			}
+			rest = rest[len(quoted):]
+		default:
+			end := strings.IndexAny(rest, " \t")
+			if end == -1 {
+				end = len(rest)
+			}
+			pattern, rest = rest[:end], rest[end:]
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, true
+}
+
+// quoteGoEmbedPattern returns the provided pattern in a form that can be used in a go:embed directive: the pattern is
+// quoted only if it contains spaces or quote characters.
+func quoteGoEmbedPattern(pattern string) string {
+	if strings.ContainsAny(pattern, " \t\"`") {
+		return strconv.Quote(pattern)
+	}
+	return pattern
+}
+
+// renameInternalPathElements returns the provided slash-separated path with every element that is exactly "internal"
+// renamed to be "internal_". The "all:" prefix of go:embed patterns is preserved.
+func renameInternalPathElements(p string) string {
+	prefix := ""
+	if rest, ok := strings.CutPrefix(p, "all:"); ok {
+		prefix, p = "all:", rest
+	}
+	elems := strings.Split(p, "/")
+	for i, elem := range elems {
+		if elem == internalDir {
+			elems[i] = internalDir + "_"
+		}
+	}
+	return prefix + strings.Join(elems, "/")
+}
+
+// isEmbedFSPath returns true if the provided value is a valid embed.FS path that contains an "internal" element and
+// that is equal to or contained in the non-wildcard prefix of one of the provided go:embed patterns.
+func isEmbedFSPath(val string, embedPatterns []string) bool {
+	if !fs.ValidPath(val) || val == "." || !slices.Contains(strings.Split(val, "/"), internalDir) {
+		return false
+	}
+	for _, pattern := range embedPatterns {
+		patternPrefix := nonWildcardPrefix(strings.TrimPrefix(pattern, "all:"))
+		if patternPrefix == "" || val == patternPrefix || strings.HasPrefix(val, patternPrefix+"/") || strings.HasPrefix(patternPrefix, val+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// nonWildcardPrefix returns the leading path elements of the provided pattern that do not contain wildcard characters.
+func nonWildcardPrefix(pattern string) string {
+	var prefixElems []string
+	for _, elem := range strings.Split(pattern, "/") {
+		if strings.ContainsAny(elem, `*?[\`) {
+			break
+		}
+		prefixElems = append(prefixElems, elem)
+	}
+	return path.Join(prefixElems...)
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rewriteEmbedPathsForRenamedInternal(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		files     map[string]string
+		wantFiles map[string]string
+	}{
+		{
+			name: "rewrites directives and embed.FS paths",
+			files: map[string]string{
+				"assets.go": `package foo
+
+import "embed"
+
+//go:embed internal/templates/*.tmpl "internal/pkg/internal/static"
+var content embed.FS
+`,
+				"foo.go": `package foo
+
+import (
+	"io/fs"
+
+	"example.com/mod/internal/bar"
+)
+
+func Files() {
+	_, _ = content.ReadFile("internal/templates/a.tmpl")
+	_, _ = fs.Sub(content, "internal/pkg/internal/static")
+	_, _ = fs.Sub(content, "internal")
+	_ = "internal/unrelated"
+	bar.Bar()
+}
+`,
+			},
+			wantFiles: map[string]string{
+				"assets.go": `package foo
+
+import "embed"
+
+//go:embed internal_/templates/*.tmpl internal_/pkg/internal_/static
+var content embed.FS
+`,
+				"foo.go": `package foo
+
+import (
+	"io/fs"
+
+	"example.com/mod/internal/bar"
+)
+
+func Files() {
+	_, _ = content.ReadFile("internal_/templates/a.tmpl")
+	_, _ = fs.Sub(content, "internal_/pkg/internal_/static")
+	_, _ = fs.Sub(content, "internal_")
+	_ = "internal/unrelated"
+	bar.Bar()
+}
+`,
+			},
+		},
+		{
+			name: "rewrites paths in nested internal package",
+			files: map[string]string{
+				"internal_/pkg/internal_/data/data.go": `package data
+
+import _ "embed"
+
+//go:embed all:internal/nested
+var nested string
+
+var path = "internal/nested/file.txt"
+`,
+			},
+			wantFiles: map[string]string{
+				"internal_/pkg/internal_/data/data.go": `package data
+
+import _ "embed"
+
+//go:embed all:internal_/nested
+var nested string
+
+var path = "internal_/nested/file.txt"
+`,
+			},
+		},
+		{
+			name: "does not modify packages without go:embed directives",
+			files: map[string]string{
+				"foo.go": `package foo
+
+var path = "internal/file.txt"
+`,
+			},
+			wantFiles: map[string]string{
+				"foo.go": `package foo
+
+var path = "internal/file.txt"
+`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			for relPath, content := range tc.files {
+				require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, relPath)), 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(tmpDir, relPath), []byte(content), 0644))
+			}
+
+			err := rewriteEmbedPathsForRenamedInternal(tmpDir)
+			require.NoError(t, err)
+
+			for relPath, want := range tc.wantFiles {
+				got, err := os.ReadFile(filepath.Join(tmpDir, relPath))
+				require.NoError(t, err)
+				assert.Equal(t, want, string(got), "unexpected content for %s", relPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/ast"
+	"path"
+	"path/filepath"
+)
+
+// envPackage is the name of the package (and of its directory in the repackaged module) that provides the environment
+// used by the code of a repackaged module.
+const envPackage = "amalgomated_env"
+
+const envPackageSrc = `// Code generated by amalgomate; DO NOT EDIT.
+
+// Package amalgomated_env provides the environment that is used by the code of a repackaged module in place of the
+// environment of the process so that it can be set when its program is run.
+package amalgomated_env
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	mu sync.RWMutex
+	// env is the environment of the module, or nil if the environment of the process is used.
+	env map[string]string
+)
+
+// Set sets the environment of the module to a copy of the provided environment and returns a function that restores
+// the previous environment. Changes made to the environment by the module do not modify the provided map or the
+// environment of the process.
+func Set(environment map[string]string) (restore func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	prevEnv := env
+	env = make(map[string]string, len(environment))
+	for key, value := range environment {
+		env[key] = value
+	}
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		env = prevEnv
+	}
+}
+
+func Getenv(key string) string {
+	value, _ := LookupEnv(key)
+	return value
+}
+
+func LookupEnv(key string) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if env == nil {
+		return os.LookupEnv(key)
+	}
+	value, ok := env[key]
+	return value, ok
+}
+
+func Setenv(key, value string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if env == nil {
+		return os.Setenv(key, value)
+	}
+	if key == "" || strings.ContainsAny(key, "=\x00") || strings.ContainsRune(value, 0) {
+		return os.NewSyscallError("setenv", syscall.EINVAL)
+	}
+	env[key] = value
+	return nil
+}
+
+func Unsetenv(key string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if env == nil {
+		return os.Unsetenv(key)
+	}
+	delete(env, key)
+	return nil
+}
+
+func Clearenv() {
+	mu.Lock()
+	defer mu.Unlock()
+	if env == nil {
+		os.Clearenv()
+		return
+	}
+	for key := range env {
+		delete(env, key)
+	}
+}
+
+func Environ() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if env == nil {
+		return os.Environ()
+	}
+	environ := make([]string, 0, len(env))
+	for key, value := range env {
+		environ = append(environ, key+"="+value)
+	}
+	sort.Strings(environ)
+	return environ
+}
+
+func ExpandEnv(s string) string {
+	return os.Expand(s, Getenv)
+}
+
+// Cmd sets the environment of the provided command to the environment of the module if the environment of the module
+// is set and the environment of the command is not, and returns the command. Calls to exec.Command and
+// exec.CommandContext are wrapped with calls to Cmd so that child processes inherit the environment of the module.
+func Cmd(cmd *exec.Cmd) *exec.Cmd {
+	mu.RLock()
+	set := env != nil
+	mu.RUnlock()
+	if set && cmd.Env == nil {
+		cmd.Env = Environ()
+	}
+	return cmd
+}
+`
+
+// envFuncs maps the import paths of the packages whose functions access the environment of the process to the names of
+// those functions. The package written by redirectEnv declares a function with the same name and signature for each of
+// them.
+var envFuncs = map[string][]string{
+	"os": {
+		"Clearenv",
+		"Environ",
+		"ExpandEnv",
+		"Getenv",
+		"LookupEnv",
+		"Setenv",
+		"Unsetenv",
+	},
+}
+
+// redirectEnv rewrites the repackaged module in repackagedModuleDir so that its environment can be set independently
+// of the environment of the process. importPath is the import path of repackagedModuleDir.
+//
+// A package named amalgomated_env is written to repackagedModuleDir. In the non-test Go files of the module, references
+// to the functions of the "os" package that access the environment (such as os.Getenv and os.Setenv) are replaced with
+// references to the corresponding functions of that package, and calls to exec.Command and exec.CommandContext are
+// wrapped with calls to its Cmd function so that the commands that do not set their environment use the environment
+// of the module.
+func redirectEnv(repackagedModuleDir, importPath string, skipDirs, buildTags []string) error {
+	envDir := filepath.Join(repackagedModuleDir, envPackage)
+	if err := writeModulePackage(envDir, []byte(envPackageSrc)); err != nil {
+		return err
+	}
+	envImportPath := path.Join(importPath, envPackage)
+
+	return rewriteModuleFiles(repackagedModuleDir, skipDirs, buildTags, func(fpath string) error {
+		return redirectEnvInFile(fpath, envImportPath)
+	})
+}
+
+// redirectEnvInFile performs the rewrite described in redirectEnv for the provided file. envImportPath is the import
+// path of the package written by redirectEnv.
+func redirectEnvInFile(fpath, envImportPath string) error {
+	return rewriteFile(fpath, envPackage, envImportPath, func(fileNode *ast.File) bool {
+		replaced := replacePackageFuncs(fileNode, envFuncs, envPackage)
+		wrapped := wrapPackageCalls(fileNode, execFuncs, envPackage, "Cmd")
+		return replaced || wrapped
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import "testing"
+
+func Test_redirectEnvInFile(t *testing.T) {
+	runRewriteFileTests(t, []rewriteFileTestCase{
+		{
+			name: "rewrites environment functions",
+			src: `package foo
+
+import "os"
+
+func F() string {
+	_ = os.Setenv("KEY", "value")
+	return os.Expand(os.Getenv("VALUE"), os.Getenv) + os.Args[0]
+}
+`,
+			want: `package foo
+
+import (
+	"os"
+	amalgomated_env "github.com/project/internal/github.com/tool/amalgomated_env"
+)
+
+func F() string {
+	_ = amalgomated_env.Setenv("KEY", "value")
+	return os.Expand(amalgomated_env.Getenv("VALUE"), amalgomated_env.Getenv) + os.Args[0]
+}
+`,
+		},
+		{
+			name: "removes unused os import",
+			src: `package foo
+
+import "os"
+
+var home, ok = os.LookupEnv("HOME")
+`,
+			want: `package foo
+
+import amalgomated_env "github.com/project/internal/github.com/tool/amalgomated_env"
+
+var home, ok = amalgomated_env.LookupEnv("HOME")
+`,
+		},
+		{
+			name: "wraps commands",
+			src: `package foo
+
+import (
+	"context"
+	"os/exec"
+)
+
+func F(ctx context.Context) error {
+	if err := exec.Command("tool", "arg").Run(); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "tool")
+	return cmd.Run()
+}
+`,
+			want: `package foo
+
+import (
+	"context"
+	"os/exec"
+	amalgomated_env "github.com/project/internal/github.com/tool/amalgomated_env"
+)
+
+func F(ctx context.Context) error {
+	if err := amalgomated_env.Cmd(exec.Command("tool", "arg")).Run(); err != nil {
+		return err
+	}
+	cmd := amalgomated_env.Cmd(exec.CommandContext(ctx, "tool"))
+	return cmd.Run()
+}
+`,
+		},
+		{
+			name: "does not modify files that do not access the environment",
+			src: `package foo
+
+import "os"
+
+func F() {
+	os.Exit(1)
+}
+`,
+			want: `package foo
+
+import "os"
+
+func F() {
+	os.Exit(1)
+}
+`,
+		},
+	}, func(fpath string) error {
+		return redirectEnvInFile(fpath, "github.com/project/internal/github.com/tool/amalgomated_env")
+	})
+}
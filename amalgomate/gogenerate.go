@@ -0,0 +1,60 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/ast"
+	"go/token"
+	"slices"
+	"strings"
+)
+
+const (
+	goGenerateDirectivePrefix = "//go:generate"
+	toolsBuildTag             = "tools"
+)
+
+// isToolsOnlyFile returns true if the provided file is only built when the "tools" build tag is set. Such files
+// (typically named "tools.go") exist only to track the dependencies of the tools used for code generation. The provided
+// build tags are considered to be set.
+func isToolsOnlyFile(fileNode *ast.File, buildTags []string) bool {
+	return excludedByBuildConstraints(fileNode, buildTags) && !excludedByBuildConstraints(fileNode, append(slices.Clip(buildTags), toolsBuildTag))
+}
+
+// removeGoGenerateDirectives returns the provided source of the provided file with all of the "//go:generate"
+// directives removed and true if any directives were removed. As with "go generate", only comments that start at the
+// beginning of a line are considered to be directives. The directives are removed from the source rather than from the
+// parsed file so that the layout of the remaining comments is preserved: lines that only contain a directive are
+// removed entirely.
+func removeGoGenerateDirectives(src []byte, fileNode *ast.File, fileSet *token.FileSet) ([]byte, bool) {
+	tokenFile := fileSet.File(fileNode.Pos())
+	var out []byte
+	prevEnd := 0
+	removed := false
+	for _, cg := range fileNode.Comments {
+		for _, c := range cg.List {
+			if !isGoGenerateDirective(c.Text) || tokenFile.Position(c.Pos()).Column != 1 {
+				continue
+			}
+			start, end := tokenFile.Offset(c.Pos()), tokenFile.Offset(c.End())
+			if end < len(src) && src[end] == '\n' {
+				end++
+			}
+			out = append(out, src[prevEnd:start]...)
+			prevEnd = end
+			removed = true
+		}
+	}
+	if !removed {
+		return src, false
+	}
+	return append(out, src[prevEnd:]...), true
+}
+
+// isGoGenerateDirective returns true if the provided comment text is a "//go:generate" directive.
+func isGoGenerateDirective(text string) bool {
+	rest, ok := strings.CutPrefix(text, goGenerateDirectivePrefix)
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t')
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_removeGoGenerateDirectives(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		src         string
+		want        string
+		wantRemoved bool
+	}{
+		{
+			name: "no directives",
+			src:  "package foo\n\n// generated by foo\nvar x = 1\n",
+			want: "package foo\n\n// generated by foo\nvar x = 1\n",
+		},
+		{
+			name:        "removes directive lines and preserves doc comments",
+			src:         "//go:generate go run gen.go\n\n// Package foo does foo.\n//go:generate stringer -type=Kind\npackage foo\n\n// Kind is a kind.\n//go:generate\ntype Kind int\n",
+			want:        "\n// Package foo does foo.\npackage foo\n\n// Kind is a kind.\ntype Kind int\n",
+			wantRemoved: true,
+		},
+		{
+			name: "ignores indented and trailing comments and other directives",
+			src:  "package foo\n\nfunc foo() {\n\t//go:generate go run gen.go\n}\n\nvar x = 1 //go:generate go run gen.go\n\n//go:generated\nvar y = 1\n",
+			want: "package foo\n\nfunc foo() {\n\t//go:generate go run gen.go\n}\n\nvar x = 1 //go:generate go run gen.go\n\n//go:generated\nvar y = 1\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fileSet := token.NewFileSet()
+			fileNode, err := parser.ParseFile(fileSet, "foo.go", tc.src, parser.ParseComments)
+			require.NoError(t, err)
+			got, removed := removeGoGenerateDirectives([]byte(tc.src), fileNode, fileSet)
+			assert.Equal(t, tc.want, string(got))
+			assert.Equal(t, tc.wantRemoved, removed)
+		})
+	}
+}
+
+func Test_isToolsOnlyFile(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		src       string
+		buildTags []string
+		want      bool
+	}{
+		{
+			name: "tools constraint",
+			src:  "//go:build tools\n\npackage tools\n",
+			want: true,
+		},
+		{
+			name: "tools constraint in +build line",
+			src:  "// +build tools\n\npackage tools\n",
+			want: true,
+		},
+		{
+			name: "no constraint",
+			src:  "package tools\n",
+		},
+		{
+			name: "ignore constraint",
+			src:  "//go:build ignore\n\npackage main\n",
+		},
+		{
+			name:      "tools tag is set",
+			src:       "//go:build tools\n\npackage tools\n",
+			buildTags: []string{"tools"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fileNode, err := parser.ParseFile(token.NewFileSet(), "tools.go", tc.src, parser.ParseComments)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, isToolsOnlyFile(fileNode, tc.buildTags))
+		})
+	}
+}
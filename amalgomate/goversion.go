@@ -0,0 +1,281 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"bytes"
+	"fmt"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"go/version"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+)
+
+const (
+	// GoVersionPolicyWarn specifies that a warning is printed when a repackaged module requires a newer Go version or
+	// toolchain than the host module.
+	GoVersionPolicyWarn = "warn"
+	// GoVersionPolicyError specifies that amalgomate fails when a repackaged module requires a newer Go version or
+	// toolchain than the host module.
+	GoVersionPolicyError = "error"
+
+	// defaultGoVersion is the Go version assumed for modules whose go.mod file does not have a "go" directive.
+	defaultGoVersion = "go1.16"
+)
+
+// goVersionIssue describes a "go" or "toolchain" directive of a repackaged module that specifies a newer version than
+// the corresponding directive of the host module.
+type goVersionIssue struct {
+	// Program is the name of the program whose module specifies the version.
+	Program string
+	// Directive is either "go" or "toolchain".
+	Directive string
+	// Version is the version required by the repackaged module (for example, "go1.22").
+	Version string
+	// HostVersion is the version required by the host module.
+	HostVersion string
+}
+
+func (i goVersionIssue) String() string {
+	return fmt.Sprintf("module for %s specifies %s %s, which is newer than the version specified by the host module (%s)", i.Program, i.Directive, i.Version, i.HostVersion)
+}
+
+// checkGoVersions compares the "go" and "toolchain" directives in the go.mod files of the provided repackaged modules
+// with the directives of the host module defined by the go.mod file at hostGoModPath and returns an issue for every
+// directive of a repackaged module that specifies a newer version. If a go.mod file does not have a "toolchain"
+// directive, its "go" directive is used as the toolchain version. Repackaged modules that do not have a go.mod file are
+// skipped.
+func checkGoVersions(hostGoModPath string, modules []repackagedModule) ([]goVersionIssue, error) {
+	hostGoMod, err := readGoModFile(hostGoModPath)
+	if err != nil {
+		return nil, err
+	}
+	hostGoVersion, hostToolchain := goModVersions(hostGoMod)
+
+	var issues []goVersionIssue
+	visitedModuleDirs := make(map[string]bool)
+	for _, module := range modules {
+		if visitedModuleDirs[module.Dir] {
+			continue
+		}
+		visitedModuleDirs[module.Dir] = true
+
+		goMod, err := readRepackagedGoModFile(module.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if goMod == nil {
+			continue
+		}
+		goVersion, toolchain := goModVersions(goMod)
+		if goVersionNewer(goVersion, hostGoVersion) {
+			issues = append(issues, goVersionIssue{
+				Program:     module.Program,
+				Directive:   "go",
+				Version:     goVersion,
+				HostVersion: hostGoVersion,
+			})
+		}
+		if goVersionNewer(toolchain, hostToolchain) {
+			issues = append(issues, goVersionIssue{
+				Program:     module.Program,
+				Directive:   "toolchain",
+				Version:     toolchain,
+				HostVersion: hostToolchain,
+			})
+		}
+	}
+	return issues, nil
+}
+
+// goModVersions returns the Go version specified by the "go" directive and the toolchain specified by the "toolchain"
+// directive of the provided go.mod file. Both values are returned in the form used by the go/version package (for
+// example, "go1.22.1"). If the file does not have a "toolchain" directive, the Go version is returned as the toolchain.
+func goModVersions(goMod *modfile.File) (goVersion, toolchain string) {
+	goVersion = defaultGoVersion
+	if goMod.Go != nil {
+		goVersion = "go" + goMod.Go.Version
+	}
+	toolchain = goVersion
+	if goMod.Toolchain != nil && version.IsValid(goMod.Toolchain.Name) {
+		toolchain = goMod.Toolchain.Name
+	}
+	return goVersion, toolchain
+}
+
+// goVersionNewer returns true if Go version v is newer than Go version w. Unlike version.Compare, a language version
+// such as "go1.21" is considered to be equal to the first release of the language version ("go1.21.0") because that is
+// the minimum toolchain that satisfies a "go 1.21" directive.
+func goVersionNewer(v, w string) bool {
+	return version.Compare(firstReleaseGoVersion(v), firstReleaseGoVersion(w)) > 0
+}
+
+func firstReleaseGoVersion(v string) string {
+	if v == version.Lang(v) {
+		return v + ".0"
+	}
+	return v
+}
+
+// readRepackagedGoModFile returns the parsed go.mod file in the provided module directory. Returns nil if the directory
+// does not contain a go.mod file (for example, if the module was copied from a vendor directory).
+func readRepackagedGoModFile(moduleDir string) (*modfile.File, error) {
+	goModPath := filepath.Join(moduleDir, "go.mod")
+	if _, err := os.Stat(goModPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return readGoModFile(goModPath)
+}
+
+// addGoVersionBuildConstraintsForModule adds a build constraint that requires the language version of the provided
+// module to every Go file in repackagedModuleDir if the language version of the module is newer than projectGoVersion.
+// Does nothing if the module does not have a go.mod file.
+func addGoVersionBuildConstraintsForModule(module *GoModInfo, repackagedModuleDir, projectGoVersion string) error {
+	goMod, err := readRepackagedGoModFile(module.Dir)
+	if err != nil {
+		return err
+	}
+	if goMod == nil {
+		return nil
+	}
+	goVersion, _ := goModVersions(goMod)
+	if version.Compare(version.Lang(goVersion), version.Lang(projectGoVersion)) <= 0 {
+		return nil
+	}
+	return addGoVersionBuildConstraints(repackagedModuleDir, version.Lang(goVersion))
+}
+
+// addGoVersionBuildConstraints adds a build constraint that requires the provided language version (for example,
+// "go1.22") to every Go file in rootDir. Because a "//go:build" constraint that specifies a Go version sets the
+// language version used to compile the file, this preserves the language version of a repackaged module whose go.mod
+// file is not copied. If a file already has a "//go:build" constraint, the version is combined with the existing
+// constraint and any "// +build" lines are removed.
+func addGoVersionBuildConstraints(rootDir, goVersion string) error {
+	return filepath.WalkDir(rootDir, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".go") {
+			return nil
+		}
+		content, err := os.ReadFile(fpath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read file %s", fpath)
+		}
+		updated, err := addGoVersionBuildConstraint(fpath, content, goVersion)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(fpath, updated, 0644); err != nil {
+			return errors.Wrapf(err, "failed to write file %s", fpath)
+		}
+		return nil
+	})
+}
+
+// addGoVersionBuildConstraint returns the provided content of the Go file at fpath with a build constraint that
+// requires goVersion.
+func addGoVersionBuildConstraint(fpath string, content []byte, goVersion string) ([]byte, error) {
+	fileSet := token.NewFileSet()
+	fileNode, err := parser.ParseFile(fileSet, fpath, content, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse file %s", fpath)
+	}
+	versionExpr := &constraint.TagExpr{Tag: goVersion}
+
+	// edits are collected in the order in which they appear in the file
+	type edit struct {
+		start, end  int
+		replacement string
+	}
+	var edits []edit
+	var plusBuildExpr constraint.Expr
+	foundGoBuild := false
+	for _, cg := range fileNode.Comments {
+		if cg.Pos() > fileNode.Package {
+			break
+		}
+		for _, c := range cg.List {
+			start, end := fileSet.Position(c.Pos()).Offset, fileSet.Position(c.End()).Offset
+			switch {
+			case constraint.IsGoBuild(c.Text):
+				expr, err := constraint.Parse(c.Text)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to parse build constraint in file %s", fpath)
+				}
+				foundGoBuild = true
+				edits = append(edits, edit{
+					start:       start,
+					end:         end,
+					replacement: "//go:build " + (&constraint.AndExpr{X: versionExpr, Y: expr}).String(),
+				})
+			case constraint.IsPlusBuild(c.Text):
+				expr, err := constraint.Parse(c.Text)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to parse build constraint in file %s", fpath)
+				}
+				// multiple "// +build" lines are combined using AND
+				if plusBuildExpr == nil {
+					plusBuildExpr = expr
+				} else {
+					plusBuildExpr = &constraint.AndExpr{X: plusBuildExpr, Y: expr}
+				}
+				// remove the line including its line break
+				if end < len(content) && content[end] == '\n' {
+					end++
+				}
+				edits = append(edits, edit{start: start, end: end})
+			}
+		}
+	}
+	if !foundGoBuild {
+		if plusBuildExpr == nil {
+			return append([]byte("//go:build "+versionExpr.String()+"\n\n"), content...), nil
+		}
+		// replace the first "// +build" line with an equivalent "//go:build" line
+		edits[0].replacement = "//go:build " + (&constraint.AndExpr{X: versionExpr, Y: plusBuildExpr}).String() + "\n"
+	}
+
+	var buf bytes.Buffer
+	prevEnd := 0
+	for _, currEdit := range edits {
+		_, _ = buf.Write(content[prevEnd:currEdit.start])
+		_, _ = buf.WriteString(currEdit.replacement)
+		prevEnd = currEdit.end
+	}
+	_, _ = buf.Write(content[prevEnd:])
+	return buf.Bytes(), nil
+}
+
+// reportGoVersions checks the Go versions of the provided repackaged modules against the Go version of the host module
+// whose root directory is hostModuleDir. Issues are written to the standard error stream if policy is
+// GoVersionPolicyWarn (or empty) and are returned as an error if policy is GoVersionPolicyError.
+func reportGoVersions(hostModuleDir string, modules []repackagedModule, policy string) error {
+	issues, err := checkGoVersions(filepath.Join(hostModuleDir, "go.mod"), modules)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check Go versions of repackaged modules")
+	}
+	if len(issues) == 0 {
+		return nil
+	}
+	if policy == GoVersionPolicyError {
+		var msgs []string
+		for _, issue := range issues {
+			msgs = append(msgs, issue.String())
+		}
+		return errors.Errorf("repackaged modules require a newer Go version than the host module:\n%s", strings.Join(msgs, "\n"))
+	}
+	for _, issue := range issues {
+		_, _ = fmt.Fprintf(os.Stderr, "amalgomate: warning: %s\n", issue)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nmiyake/pkg/gofiles"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckGoVersions(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "host/go.mod",
+			Src:     "module example.com/host\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "older/go.mod",
+			Src:     "module example.com/older\n\ngo 1.20\n\ntoolchain go1.21.0\n",
+		},
+		{
+			RelPath: "newer/go.mod",
+			Src:     "module example.com/newer\n\ngo 1.22.1\n",
+		},
+		{
+			RelPath: "toolchain/go.mod",
+			Src:     "module example.com/toolchain\n\ngo 1.21\n\ntoolchain go1.23.4\n",
+		},
+	})
+	require.NoError(t, err)
+
+	issues, err := checkGoVersions(filepath.Join(tmpDir, "host", "go.mod"), []repackagedModule{
+		{Program: "older", Path: "example.com/older", Dir: filepath.Join(tmpDir, "older")},
+		{Program: "newer", Path: "example.com/newer", Dir: filepath.Join(tmpDir, "newer")},
+		{Program: "toolchain", Path: "example.com/toolchain", Dir: filepath.Join(tmpDir, "toolchain")},
+	})
+	require.NoError(t, err)
+	var gotIssues []string
+	for _, issue := range issues {
+		gotIssues = append(gotIssues, issue.String())
+	}
+	assert.Equal(t, []string{
+		"module for newer specifies go go1.22.1, which is newer than the version specified by the host module (go1.21)",
+		"module for newer specifies toolchain go1.22.1, which is newer than the version specified by the host module (go1.21)",
+		"module for toolchain specifies toolchain go1.23.4, which is newer than the version specified by the host module (go1.21)",
+	}, gotIssues)
+}
+
+func Test_addGoVersionBuildConstraint(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "file without constraint",
+			in: `// Package foo does things.
+package foo
+`,
+			want: `//go:build go1.22
+
+// Package foo does things.
+package foo
+`,
+		},
+		{
+			name: "file with constraint",
+			in: `// Copyright notice.
+
+//go:build linux || darwin
+
+package foo
+`,
+			want: `// Copyright notice.
+
+//go:build go1.22 && (linux || darwin)
+
+package foo
+`,
+		},
+		{
+			name: "file with go:build and +build constraints",
+			in: `//go:build linux
+// +build linux
+
+package foo
+`,
+			want: `//go:build go1.22 && linux
+
+package foo
+`,
+		},
+		{
+			name: "file with only +build constraints",
+			in: `// +build linux darwin
+// +build amd64
+
+package foo
+`,
+			want: `//go:build go1.22 && (linux || darwin) && amd64
+
+package foo
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := addGoVersionBuildConstraint("foo.go", []byte(tc.in), "go1.22")
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+}
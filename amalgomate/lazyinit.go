@@ -0,0 +1,150 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// lazyInitPackage is the name of the package (and of its directory in the repackaged module) that runs the rewritten
+// init functions of a repackaged module.
+const lazyInitPackage = "amalgomated_init"
+
+const lazyInitPackageSrc = `// Code generated by amalgomate; DO NOT EDIT.
+
+// Package amalgomated_init runs the init functions of a repackaged module when its program is run rather than when
+// the amalgomated program starts.
+package amalgomated_init
+
+import "sync"
+
+var (
+	once  sync.Once
+	funcs []func()
+)
+
+// Register registers a function that is run by Run. The rewritten init functions of the module register their
+// original bodies, so the functions are registered in the order in which the init functions would have run.
+func Register(f func()) {
+	funcs = append(funcs, f)
+}
+
+// Run runs the registered functions in the order in which they were registered. Only the first call has an effect.
+func Run() {
+	once.Do(func() {
+		for _, f := range funcs {
+			f()
+		}
+	})
+}
+`
+
+// lazyInitEntrypoint identifies the function that starts a program: the function named Name in the package named
+// Package.
+type lazyInitEntrypoint struct {
+	Package string
+	Name    string
+}
+
+// rewriteInitFunctions rewrites the init functions of the repackaged module in repackagedModuleDir so that they are run
+// when the module's program is run rather than when the amalgomated program starts. importPath is the import path of
+// repackagedModuleDir.
+//
+// A package named amalgomated_init is written to repackagedModuleDir. The body of every init function in the non-test
+// Go files of the module is wrapped in a function literal that is registered with that package, and a call that runs
+// the registered functions is added to the start of the provided entrypoints. Because the registration is performed by
+// the init functions themselves, the functions are run in the order in which the init functions would have run. The
+// initialization expressions of package-level variables are not affected, so they are still evaluated when the
+// amalgomated program starts. Repackaged tests are not supported because they do not call an entrypoint.
+func rewriteInitFunctions(repackagedModuleDir, importPath string, entrypoints []lazyInitEntrypoint, skipDirs, buildTags []string) error {
+	lazyInitDir := filepath.Join(repackagedModuleDir, lazyInitPackage)
+	if err := os.MkdirAll(lazyInitDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", lazyInitDir)
+	}
+	if err := os.WriteFile(filepath.Join(lazyInitDir, lazyInitPackage+".go"), []byte(lazyInitPackageSrc), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write file in %s", lazyInitDir)
+	}
+	lazyInitImportPath := path.Join(importPath, lazyInitPackage)
+
+	return rewriteModuleFiles(repackagedModuleDir, skipDirs, buildTags, func(fpath string) error {
+		return rewriteInitFunctionsInFile(fpath, lazyInitImportPath, entrypoints)
+	})
+}
+
+// rewriteInitFunctionsInFile performs the rewrite described in rewriteInitFunctions for the provided file. The file is
+// rewritten as source text and then parsed and printed so that the comments in the original function bodies keep their
+// positions.
+func rewriteInitFunctionsInFile(fpath, lazyInitImportPath string, entrypoints []lazyInitEntrypoint) error {
+	src, err := os.ReadFile(fpath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read file %s", fpath)
+	}
+	fileSet := token.NewFileSet()
+	fileNode, err := parser.ParseFile(fileSet, fpath, src, parser.ParseComments)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse file %s", fpath)
+	}
+
+	type insertion struct {
+		offset int
+		text   string
+	}
+	var insertions []insertion
+	tokenFile := fileSet.File(fileNode.Pos())
+	for _, decl := range fileNode.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv != nil || funcDecl.Body == nil {
+			continue
+		}
+		switch {
+		case funcDecl.Name.Name == "init":
+			insertions = append(insertions,
+				insertion{offset: tokenFile.Offset(funcDecl.Body.Lbrace) + 1, text: "\n" + lazyInitPackage + ".Register(func() {"},
+				insertion{offset: tokenFile.Offset(funcDecl.Body.Rbrace), text: "})\n"},
+			)
+		case slices.Contains(entrypoints, lazyInitEntrypoint{Package: fileNode.Name.Name, Name: funcDecl.Name.Name}):
+			insertions = append(insertions,
+				insertion{offset: tokenFile.Offset(funcDecl.Body.Lbrace) + 1, text: "\n" + lazyInitPackage + ".Run()"},
+			)
+		}
+	}
+	if len(insertions) == 0 {
+		return nil
+	}
+
+	// apply insertions from the end of the file so that the offsets of earlier insertions remain valid. Insertions at
+	// the same offset (the start and end of an empty function body) are applied in reverse order so that they appear
+	// in the order in which they were added.
+	sort.SliceStable(insertions, func(i, j int) bool {
+		return insertions[i].offset < insertions[j].offset
+	})
+	rewrittenSrc := slices.Clone(src)
+	for i := len(insertions) - 1; i >= 0; i-- {
+		rewrittenSrc = slices.Insert(rewrittenSrc, insertions[i].offset, []byte(insertions[i].text)...)
+	}
+
+	fileSet = token.NewFileSet()
+	fileNode, err = parser.ParseFile(fileSet, fpath, rewrittenSrc, parser.ParseComments)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse file %s after rewriting init functions", fpath)
+	}
+	if !astutil.AddNamedImport(fileSet, fileNode, lazyInitPackage, lazyInitImportPath) {
+		return errors.Errorf("failed to add import %s to file %s", lazyInitImportPath, fpath)
+	}
+	if err := writeAstToFile(fpath, fileNode, fileSet); err != nil {
+		return errors.Wrapf(err, "failed to write rewritten file %s", fpath)
+	}
+	return nil
+}
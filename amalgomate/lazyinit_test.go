@@ -0,0 +1,76 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import "testing"
+
+func Test_rewriteInitFunctionsInFile(t *testing.T) {
+	runRewriteFileTests(t, []rewriteFileTestCase{
+		{
+			name: "rewrites init functions and entrypoint",
+			src: `package amalgomated
+
+import "fmt"
+
+func init() {
+	// print a message
+	fmt.Println("first")
+}
+
+func init() {}
+
+func AmalgomatedMain() {
+	fmt.Println("main")
+}
+`,
+			want: `package amalgomated
+
+import (
+	"fmt"
+	amalgomated_init "github.com/project/internal/github.com/tool/amalgomated_init"
+)
+
+func init() {
+	amalgomated_init.Register(func() {
+		// print a message
+		fmt.Println("first")
+	})
+}
+
+func init() {
+	amalgomated_init.Register(func() {})
+}
+
+func AmalgomatedMain() {
+	amalgomated_init.Run()
+	fmt.Println("main")
+}
+`,
+		},
+		{
+			name: "does not rewrite methods or functions in other packages",
+			src: `package foo
+
+type T struct{}
+
+func (T) init() {}
+
+func AmalgomatedMain() {}
+`,
+			want: `package foo
+
+type T struct{}
+
+func (T) init() {}
+
+func AmalgomatedMain() {}
+`,
+		},
+	}, func(fpath string) error {
+		return rewriteInitFunctionsInFile(fpath, "github.com/project/internal/github.com/tool/amalgomated_init", []lazyInitEntrypoint{
+			{Package: amalgomatedPackage, Name: amalgomatedMain},
+		})
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// lockPackage is the name of the package (and of its directory in the repackaged module) that serializes the
+// invocations of the programs of a repackaged module by a generated library.
+const lockPackage = "amalgomated_lock"
+
+var lockPackageTemplate = template.Must(template.New("lock").Parse(`// Code generated by amalgomate; DO NOT EDIT.
+
+// Package amalgomated_lock serializes the invocations of the programs of a repackaged module. The state that is set
+// for an invocation (such as its environment or standard streams) and the package-level variables that are reset
+// before it are shared by all of the programs of the module, so only one of them may run at a time.
+package amalgomated_lock
+
+import (
+{{- if .}}
+	flag "{{.}}"
+{{- else}}
+	"sync"
+{{- end}}
+)
+{{if .}}
+// the repackaged "flag" package (including its standard streams) is shared by all of the modules that are repackaged
+// alongside this one, so its lock is used by all of them
+var mu = &flag.AmalgomatedLock
+{{- else}}
+var mu sync.Mutex
+{{- end}}
+
+// Lock waits until no other program of the module is running and returns a function that allows the next one to run.
+// A program that runs another program of the same module through the library deadlocks.
+func Lock() (unlock func()) {
+	mu.Lock()
+	return mu.Unlock
+}
+`))
+
+const flagLockSrc = `// Code generated by amalgomate; DO NOT EDIT.
+
+package flag
+
+import "sync"
+
+// AmalgomatedLock is held while a program of a module that uses this package is run by a generated library.
+var AmalgomatedLock sync.Mutex
+`
+
+// writeLockPackage writes the package that serializes the invocations of the programs of the repackaged module in
+// repackagedModuleDir. flagImportPath is the import path of the repackaged "flag" package (or blank if the "flag"
+// package was not repackaged): if it is non-empty, the lock of that package is used so that the programs of all of the
+// modules that share it are serialized.
+func writeLockPackage(repackagedModuleDir, flagImportPath string) error {
+	var src bytes.Buffer
+	if err := lockPackageTemplate.Execute(&src, flagImportPath); err != nil {
+		return errors.Wrapf(err, "failed to execute template")
+	}
+	return writeModulePackage(filepath.Join(repackagedModuleDir, lockPackage), src.Bytes())
+}
+
+// writeFlagLock adds the exported AmalgomatedLock variable to the repackaged "flag" package in flagDir.
+func writeFlagLock(flagDir string) error {
+	if err := os.WriteFile(filepath.Join(flagDir, lockPackage+".go"), []byte(flagLockSrc), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write file in %s", flagDir)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ package amalgomate
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
@@ -36,21 +37,45 @@ import (
 // possible that "github.com/repackage/nested-module" is defined as a separate module, and in that case any imports to
 // that path or subpath would not be rewritten, even though from a "path" perspective it would seem that this might be
 // part of the "github.com/repackage" module. The import operation that determines whether a package is part of a module
-// is performed relative to "repackagedModuleRootDir".
+// is performed relative to "resolveDir", which is typically "repackagedModuleRootDir" (but may differ if the module
+// being repackaged is not a dependency of the module that contains "repackagedModuleRootDir").
 //
-// If "renameInternal" is true, then any import paths that have "internal" (in the original source" will be updated to
-// be "internal_" instead.
-func rewriteImports(
-	repackagedModuleRootDir,
-	moduleImportPath,
-	importPathToRepackagedModule string,
-	doNotRewriteFlagImport []string,
-	renameInternal bool,
-) error {
+// The imports of all Go files are rewritten regardless of their build constraints. Files in "main" packages are changed
+// to be in the package opts.PackageName, and the "main" function is renamed to be opts.EntrypointName. The provided
+// rewriteOptions control the rest of the rewrite (see the documentation of the fields of rewriteOptions).
+func rewriteImports(repackagedModuleRootDir, moduleImportPath, importPathToRepackagedModule, resolveDir string, opts rewriteOptions) error {
 	fileSet := token.NewFileSet()
 	foundMain := false
 	flagPkgImported := false
 
+	// repackagedImportPath returns the import path of the repackaged package for the provided import path and true if
+	// the import path refers to a package in the module being repackaged. Returns false otherwise.
+	repackagedImportPath := func(importPath string) (string, bool, error) {
+		// packages in the module must have the module path as a prefix, so import paths without the prefix do not need
+		// to be resolved
+		if importPath != moduleImportPath && !strings.HasPrefix(importPath, moduleImportPath+"/") {
+			return "", false, nil
+		}
+
+		goModInfo, err := moduleInfoForPackage(importPath, resolveDir)
+		if err != nil {
+			return "", false, err
+		}
+
+		// import belongs to module other than one being repackaged: nothing to do
+		if goModInfo.Path != moduleImportPath {
+			return "", false, nil
+		}
+
+		if opts.RenameInternal {
+			importPath = strings.ReplaceAll(importPath, "/internal/", "/internal_/")
+			if strings.HasSuffix(importPath, "/internal") {
+				importPath += "_"
+			}
+		}
+		return path.Join(importPathToRepackagedModule, importPath), true, nil
+	}
+
 	if err := filepath.WalkDir(repackagedModuleRootDir, func(fpath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -67,70 +92,89 @@ func rewriteImports(
 			return errors.Wrapf(err, "failed to parse file %s", fpath)
 		}
 
+		if opts.StripGoGenerate {
+			// files that only declare the dependencies of code generation tools are not needed
+			if isToolsOnlyFile(fileNode, opts.BuildTags) {
+				if err := os.Remove(fpath); err != nil {
+					return errors.Wrapf(err, "failed to remove file %s", fpath)
+				}
+				return nil
+			}
+			src, err := os.ReadFile(fpath)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read file %s", fpath)
+			}
+			if strippedSrc, removed := removeGoGenerateDirectives(src, fileNode, fileSet); removed {
+				if fileNode, err = parser.ParseFile(fileSet, fpath, strippedSrc, parser.ParseComments); err != nil {
+					return errors.Wrapf(err, "failed to parse file %s after removing go:generate directives", fpath)
+				}
+				updated = true
+			}
+		}
+
 		for _, currImport := range fileNode.Imports {
 			currImportPathUnquoted, err := strconv.Unquote(currImport.Path.Value)
 			if err != nil {
 				return errors.Wrapf(err, "unable to unquote import %s", currImport.Path.Value)
 			}
 
+			var updatedImport string
 			if currImportPathUnquoted == "flag" {
 				// no need to update flag import if file is in the "do not rewrite" list
 				currFileRelPath := strings.TrimPrefix(fpath, repackagedModuleRootDir+string(os.PathSeparator))
-				if slices.Contains(doNotRewriteFlagImport, currFileRelPath) {
+				if slices.Contains(opts.DoNotRewriteFlagImport, currFileRelPath) {
 					continue
 				}
+				flagPkgImported = true
+				updatedImport = filepath.Join(importPathToRepackagedModule, "amalgomated_flag")
 			} else {
 				// no need to repackage standard library packages that are not "flag"
 				if inStandardLibrary(currImportPathUnquoted) {
 					continue
 				}
 
-				goModInfo, err := moduleInfoForPackage(currImportPathUnquoted, repackagedModuleRootDir)
+				var ok bool
+				updatedImport, ok, err = repackagedImportPath(currImportPathUnquoted)
 				if err != nil {
 					return err
 				}
-
-				// import belongs to module other than one being repackaged: nothing to do
-				if goModInfo.Path != moduleImportPath {
+				if !ok {
 					continue
 				}
 			}
 			updated = true
 
-			// Save the original import path for astutil.RewriteImport
-			originalImportPath := currImportPathUnquoted
-
-			var updatedImport string
-			if currImportPathUnquoted == "flag" {
-				flagPkgImported = true
-				updatedImport = filepath.Join(importPathToRepackagedModule, "amalgomated_flag")
-			} else {
-				if renameInternal {
-					currImportPathUnquoted = strings.ReplaceAll(currImportPathUnquoted, "/internal/", "/internal_/")
-					if strings.HasSuffix(currImportPathUnquoted, "/internal") {
-						currImportPathUnquoted += "_"
-					}
-				}
-				updatedImport = path.Join(importPathToRepackagedModule, currImportPathUnquoted)
-			}
-
-			if !astutil.RewriteImport(fileSet, fileNode, originalImportPath, updatedImport) {
-				return errors.Errorf("failed to rewrite import from %s to %s", originalImportPath, updatedImport)
+			if !astutil.RewriteImport(fileSet, fileNode, currImportPathUnquoted, updatedImport) {
+				return errors.Errorf("failed to rewrite import from %s to %s", currImportPathUnquoted, updatedImport)
 			}
 
 			removeImportPathChecking(fileNode)
 		}
 
-		// change package name for main packages
-		if fileNode.Name.Name == "main" {
+		// rewrite go:linkname directives that refer to symbols in the module
+		linknameUpdated, err := rewriteLinknameDirectives(fileNode, repackagedImportPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to rewrite go:linkname directives in file %s", fpath)
+		}
+		updated = updated || linknameUpdated
+
+		// change package name for main packages. Files that are excluded by their build constraints (such as programs
+		// that are run using "go run" and have the constraint "//go:build ignore") are not part of the package, so they
+		// are left as-is.
+		if fileNode.Name.Name == "main" && !excludedByBuildConstraints(fileNode, opts.BuildTags) {
 			updated = true
 
-			fileNode.Name = ast.NewIdent(amalgomatedPackage)
+			fileNode.Name = ast.NewIdent(opts.PackageName)
+			if opts.CopyTests {
+				// calls to the main function (which are typically made by the copied tests) must call the renamed
+				// function
+				renameFunctionCalls(fileNode, "main", opts.EntrypointName)
+			}
 
 			// find the main function
 			mainFunc := findFunction(fileNode, "main")
 			if mainFunc != nil {
-				err = renameFunction(fileNode, "main", amalgomatedMain)
+				err = renameFunction(fileNode, "main", opts.EntrypointName)
 				if err != nil {
 					return errors.Wrapf(err, "failed to rename function in file %s", fpath)
 				}
@@ -154,6 +198,13 @@ func rewriteImports(
 		return errors.Errorf("main method not found in repackaged module directory tree %s", repackagedModuleRootDir)
 	}
 
+	if opts.RenameInternal {
+		// "internal" directories were renamed when the module was copied, so update the paths of embedded files
+		if err := rewriteEmbedPathsForRenamedInternal(filepath.Join(repackagedModuleRootDir, moduleImportPath)); err != nil {
+			return errors.Wrapf(err, "failed to rewrite go:embed paths")
+		}
+	}
+
 	if flagPkgImported {
 		// if "flag" package is imported, add "flag" as a rewritten dependency. This is done because flag.CommandLine is
 		// a global variable that is often used by programs and problems can arise if multiple amalgomated programs use
@@ -164,6 +215,10 @@ func rewriteImports(
 		}
 		fmtSrcDir := path.Join(goRoot, "src", "flag")
 		fmtDstDir := path.Join(repackagedModuleRootDir, "amalgomated_flag")
+		// remove any copy made while repackaging a previous program, as os.CopyFS does not overwrite existing files
+		if err := os.RemoveAll(fmtDstDir); err != nil {
+			return errors.Wrapf(err, "failed to remove directory %s", fmtDstDir)
+		}
 		if err := os.CopyFS(fmtDstDir, os.DirFS(fmtSrcDir)); err != nil {
 			return errors.Wrapf(err, "failed to copy directory %s to %s", fmtSrcDir, fmtDstDir)
 		}
@@ -188,15 +243,62 @@ func rewriteImports(
 
 // copyEmbedFilesForPackage loads the package at packageDir and copies all files referenced by go:embed directives
 // from srcDir to dstRootPath, maintaining relative paths and applying renameInternal transformations if specified.
-func copyEmbedFilesForPackage(packageDir, srcDir, dstRootPath string, renameInternal bool) error {
+// The package is loaded for every target platform of the provided buildOptions, so the files embedded by the files of
+// any target platform are copied. Returns an error that identifies the package and its go:embed patterns if the
+// patterns cannot be resolved or if a file cannot be copied.
+func copyEmbedFilesForPackage(packageDir, srcDir, dstRootPath string, renameInternal bool, build buildOptions) error {
 	// Load the package at this directory to get embed file information
-	pkg, err := packageForPatternInDirectory(".", packageDir, packages.NeedName|packages.NeedFiles|packages.NeedEmbedFiles)
+	pkgs, err := packagesForPatternInDirectoryForTargets(".", packageDir, packages.NeedName|packages.NeedFiles|packages.NeedEmbedFiles|packages.NeedEmbedPatterns, build)
 	if err != nil {
-		return errors.Wrapf(err, "failed to load package at directory %s", packageDir)
+		return errors.Wrapf(err, "failed to load package at directory %s to resolve go:embed patterns", packageDir)
+	}
+
+	var pkgPath string
+	var embedFiles, allEmbedPatterns []string
+	for _, pkg := range pkgs {
+		pkgPath = pkg.PkgPath
+
+		// package loading reports patterns as absolute paths, so make them relative to the package directory for
+		// messages
+		var embedPatterns []string
+		for _, pattern := range pkg.EmbedPatterns {
+			if relPattern, err := filepath.Rel(packageDir, pattern); err == nil {
+				pattern = filepath.ToSlash(relPattern)
+			}
+			embedPatterns = append(embedPatterns, pattern)
+		}
+
+		// errors are only relevant if the package embeds files and is built for the target platform: package loading
+		// may fail for reasons that do not affect repackaging (for example, if dependencies cannot be resolved from
+		// the module directory)
+		if len(pkg.Errors) > 0 && !excludedForTarget(pkg) {
+			hasEmbed, err := dirContainsEmbedDirective(packageDir)
+			if err != nil {
+				return err
+			}
+			if len(embedPatterns) > 0 || hasEmbed {
+				var msgs []string
+				for _, pkgErr := range pkg.Errors {
+					msgs = append(msgs, pkgErr.Msg)
+				}
+				return errors.Errorf("failed to resolve go:embed patterns %v of package %s in directory %s: %s", embedPatterns, pkg.PkgPath, packageDir, strings.Join(msgs, "; "))
+			}
+		}
+
+		for _, pattern := range embedPatterns {
+			if !slices.Contains(allEmbedPatterns, pattern) {
+				allEmbedPatterns = append(allEmbedPatterns, pattern)
+			}
+		}
+		for _, embedFile := range pkg.EmbedFiles {
+			if !slices.Contains(embedFiles, embedFile) {
+				embedFiles = append(embedFiles, embedFile)
+			}
+		}
 	}
 
 	// Copy all embed files for this package
-	for _, embedFile := range pkg.EmbedFiles {
+	for _, embedFile := range embedFiles {
 		// Make embed file path relative to the source directory
 		relEmbedPath, err := filepath.Rel(srcDir, embedFile)
 		if err != nil {
@@ -224,18 +326,80 @@ func copyEmbedFilesForPackage(packageDir, srcDir, dstRootPath string, renameInte
 		// Create parent directory if it doesn't exist
 		dstEmbedDir := filepath.Dir(dstEmbedPath)
 		if err := os.MkdirAll(dstEmbedDir, 0755); err != nil {
-			return errors.Wrapf(err, "failed to create directory for embed file at %s", dstEmbedDir)
+			return errors.Wrapf(err, "failed to create directory for file %s embedded by package %s", dstEmbedDir, pkgPath)
 		}
 
 		// Copy the embed file
 		if err := copy.Copy(embedFile, dstEmbedPath); err != nil {
-			return errors.Wrapf(err, "failed to copy embed file from %s to %s", embedFile, dstEmbedPath)
+			return errors.Wrapf(err, "failed to copy file %s matched by go:embed patterns %v of package %s to %s", embedFile, allEmbedPatterns, pkgPath, dstEmbedPath)
 		}
 	}
 
 	return nil
 }
 
+// dirContainsEmbedDirective returns true if any of the ".go" files in the provided directory contain a go:embed
+// directive.
+func dirContainsEmbedDirective(dir string) (bool, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list directory %s", dir)
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".go") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, dirEntry.Name()))
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to read file %s", filepath.Join(dir, dirEntry.Name()))
+		}
+		if bytes.Contains(content, []byte("//go:embed ")) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rewriteOptions specifies the options for rewriteImports.
+type rewriteOptions struct {
+	// DoNotRewriteFlagImport contains the paths (relative to the repackaged module) of the files whose imports of the
+	// "flag" package are not rewritten to import the repackaged "flag" package.
+	DoNotRewriteFlagImport []string
+	// RenameInternal specifies that any import paths that have "internal" (in the original source) are updated to be
+	// "internal_" instead, as are go:embed directives and embed.FS paths (see rewriteEmbedPathsForRenamedInternal).
+	RenameInternal bool
+	// BuildTags are the build tags that are considered to be set when determining whether a "main" file is excluded
+	// from every build.
+	BuildTags []string
+	// StripGoGenerate specifies that all "//go:generate" directives are removed and files that are only built with the
+	// "tools" build tag are deleted so that running "go generate" in the output module does not run the generators of
+	// the repackaged module.
+	StripGoGenerate bool
+	// PackageName is the name to which "main" packages are renamed.
+	PackageName string
+	// EntrypointName is the name to which the "main" function is renamed.
+	EntrypointName string
+	// CopyTests specifies that the test files of the module were copied, in which case the calls to the "main"
+	// function in "main" packages (which are typically made by the tests) are renamed to call EntrypointName.
+	CopyTests bool
+}
+
+// copyOptions specifies the options for copyModuleRecursively.
+type copyOptions struct {
+	// RenameInternal specifies that any directories from the source directory with the name "internal" are renamed to
+	// be "internal_" when copied to the destination. This has the effect of making the internal packages of the module
+	// accessible to the rest of the output module.
+	RenameInternal bool
+	// CopyTests specifies that files with the suffix "_test.go" and the full contents of all directories named
+	// "testdata" are copied as well.
+	CopyTests bool
+	// LenientEmbed specifies that failures to resolve or copy the files referenced by go:embed directives are printed
+	// as warnings rather than returned as errors.
+	LenientEmbed bool
+	// Build specifies the build tags and target platforms used to resolve the files referenced by go:embed directives.
+	Build buildOptions
+}
+
 // copyModuleRecursively recursively copies the module with the canonical name modulePath from srcDir into dstDir. Only
 // copies files with the suffix ".go", omits files with the suffix "_test.go" and skips all directories named "vendor".
 // The contents of srcDir are copied into the directory path that consists of the module path converted into a file
@@ -254,9 +418,8 @@ func copyEmbedFilesForPackage(packageDir, srcDir, dstRootPath string, renameInte
 // "dstDir/modulePath" path. The permissions for all created directories will be 0755 regardless of the source directory
 // permissions. Does not follow symlinks.
 //
-// If "renameInternal" is true, then any directories from srcDir with the name "internal" are renamed to be "internal_"
-// when copied to the destination. This has the effect of making the internal
-func copyModuleRecursively(modulePath, srcDir, dstDir string, renameInternal bool) error {
+// The provided copyOptions control how the module is copied (see the documentation of the fields of copyOptions).
+func copyModuleRecursively(modulePath, srcDir, dstDir string, opts copyOptions) error {
 	if !filepath.IsAbs(srcDir) {
 		srcDirAbsPath, err := filepath.Abs(srcDir)
 		if err != nil {
@@ -287,11 +450,14 @@ func copyModuleRecursively(modulePath, srcDir, dstDir string, renameInternal boo
 			return err
 		}
 
+		copyDir := false
 		if d.IsDir() {
 			// fully skip any directories named "vendor"
 			if d.Name() == "vendor" {
 				return fs.SkipDir
 			}
+			// "testdata" directories are ignored by the Go tool, so they are copied in full without being processed
+			copyDir = opts.CopyTests && d.Name() == "testdata"
 
 			hasGoFiles, err := dirContainsGoFiles(path)
 			if err != nil {
@@ -301,7 +467,7 @@ func copyModuleRecursively(modulePath, srcDir, dstDir string, renameInternal boo
 			// only check module of directory if it has Go files -- otherwise, module lookup won't succeed. This will
 			// result in an extra directory if all the directories within it are modules that are not the target, but
 			// there is limited downside to this.
-			if hasGoFiles {
+			if hasGoFiles && !copyDir {
 				// if this is a directory, verify that it is part of the desired module. If not, do not process the
 				// directory or any of its contents.
 				currPathModulePath, err := modulePathForDirectory(path)
@@ -313,14 +479,15 @@ func copyModuleRecursively(modulePath, srcDir, dstDir string, renameInternal boo
 				}
 
 				// Copy any files referenced by go:embed directives in this package
-				if err := copyEmbedFilesForPackage(path, srcDir, dstRootPath, renameInternal); err != nil {
-					// TODO: log this error in the future instead of silently continuing
-					// For now, continue even if embed file copying fails to maintain backwards compatibility
-					_ = err
+				if err := copyEmbedFilesForPackage(path, srcDir, dstRootPath, opts.RenameInternal, opts.Build); err != nil {
+					if !opts.LenientEmbed {
+						return err
+					}
+					_, _ = fmt.Fprintf(os.Stderr, "amalgomate: warning: %v\n", err)
 				}
 			}
-		} else if !strings.HasSuffix(d.Name(), ".go") || strings.HasSuffix(d.Name(), "_test.go") {
-			// skip non-".go" files and "_test.go" files
+		} else if !strings.HasSuffix(d.Name(), ".go") || (!opts.CopyTests && strings.HasSuffix(d.Name(), "_test.go")) {
+			// skip non-".go" files and "_test.go" files (unless tests are copied)
 			return nil
 		}
 
@@ -334,8 +501,8 @@ func copyModuleRecursively(modulePath, srcDir, dstDir string, renameInternal boo
 			return nil
 		}
 
-		// if renameInternal is true, rewrite "internal" directories to be "internal_" instead
-		if renameInternal {
+		// if RenameInternal is true, rewrite "internal" directories to be "internal_" instead
+		if opts.RenameInternal {
 			// handle when the path is exactly "internal" (the directory itself)
 			if relPathToSrc == "internal" {
 				relPathToSrc = "internal_"
@@ -353,7 +520,12 @@ func copyModuleRecursively(modulePath, srcDir, dstDir string, renameInternal boo
 			}
 		}
 		dstPath := filepath.Join(dstRootPath, relPathToSrc)
-		if d.IsDir() {
+		if copyDir {
+			if err := copy.Copy(path, dstPath); err != nil {
+				return errors.Wrapf(err, "failed to copy %s to %s", path, dstPath)
+			}
+			return fs.SkipDir
+		} else if d.IsDir() {
 			if err := os.MkdirAll(dstPath, 0755); err != nil {
 				return errors.Wrapf(err, "failed to create directory at %s", dstPath)
 			}
@@ -382,6 +554,38 @@ func dirContainsGoFiles(dir string) (bool, error) {
 	return false, nil
 }
 
+// resolveMainPkg returns the import path of the main package of the provided SrcPkg and the directory from which it
+// should be resolved. If the SrcPkg specifies a directory, the import path is derived from the module that contains the
+// directory and the directory is returned as the directory from which to resolve it. If the SrcPkg specifies a version,
+// the directory is that of a module that requires the specified version of the module that provides the main package
+// (see pinnedModuleDir). Otherwise, the main package is resolved from outputDir.
+func resolveMainPkg(pkg SrcPkg, outputDir string) (mainPkg, resolveDir string, rErr error) {
+	switch {
+	case pkg.Dir != "":
+		mainPkgDir, err := filepath.Abs(pkg.Dir)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "failed to convert %s into absolute path", pkg.Dir)
+		}
+		modInfo, err := moduleInfoForDirectory(mainPkgDir)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "failed to determine module for directory %s", mainPkgDir)
+		}
+		relPathFromModule, err := relpathNormalizedPaths(modInfo.Dir, mainPkgDir)
+		if err != nil {
+			return "", "", err
+		}
+		return path.Join(modInfo.Path, filepath.ToSlash(relPathFromModule)), mainPkgDir, nil
+	case pkg.Version != "":
+		moduleDir, err := pinnedModuleDir(pkg.MainPkg, pkg.Version)
+		if err != nil {
+			return "", "", err
+		}
+		return pkg.MainPkg, moduleDir, nil
+	default:
+		return pkg.MainPkg, outputDir, nil
+	}
+}
+
 // moduleInfoForPackage returns the GoModInfo for the package with the specified import path resolved in the provided
 // directory. The returned GoModInfo contains the module path (the module name/import path) and the path to the
 // directory on disk where the module is located. The module path on disk will reflect the location from which the
@@ -453,6 +657,7 @@ func moduleInfoForPackage(pkgName, dir string) (*GoModInfo, error) {
 	goListCmd.Stdout = stdoutBuf
 
 	goListCmd.Dir = dir
+	goListCmd.Env = goEnvForDir(dir)
 	if err := goListCmd.Run(); err != nil {
 		return nil, errors.Wrapf(err, "failed to run command %v: %s", goListCmd.Args, stdoutBuf.String())
 	}
@@ -486,6 +691,7 @@ func moduleInfoForPackage(pkgName, dir string) (*GoModInfo, error) {
 func packageForPatternInDirectory(pattern, dir string, mode packages.LoadMode) (*packages.Package, error) {
 	outputDirPkgs, err := packages.Load(&packages.Config{
 		Dir:  dir,
+		Env:  goEnvForDir(dir),
 		Mode: mode,
 	}, pattern)
 	if err != nil {
@@ -527,20 +733,76 @@ func modulePathForDirectory(dir string) (string, error) {
 }
 
 // moduleInfoForDirectory returns the *GoModInfo for the specified directory. Returns the result of running
-// "go list -mod=readonly -m -json" using the provided directory as the working directory.
+// "go list -mod=readonly -m -json" using the provided directory as the working directory. If the directory is part of
+// a workspace (defined by a "go.work" file), the command returns information for every module in the workspace, in
+// which case the module whose directory contains the provided directory is returned (if multiple modules contain the
+// directory, the one whose directory is the longest, which is the innermost module, is returned).
 func moduleInfoForDirectory(dir string) (*GoModInfo, error) {
 	goListCmd := exec.Command("go", "list", "-mod=readonly", "-m", "-json")
 	goListCmd.Dir = dir
-	output, err := goListCmd.CombinedOutput()
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to execute command %v in directory %s. Output: %s", goListCmd.Args, dir, string(output))
+	goListCmd.Env = goEnvForDir(dir)
+
+	// only consume stdout output since JSON is written to stdout
+	stdoutBuf := &bytes.Buffer{}
+	stderrBuf := &bytes.Buffer{}
+	goListCmd.Stdout = stdoutBuf
+	goListCmd.Stderr = stderrBuf
+	if err := goListCmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "failed to execute command %v in directory %s. Output: %s", goListCmd.Args, dir, stdoutBuf.String()+stderrBuf.String())
 	}
-	var modInfo GoModInfo
-	if err := json.Unmarshal(output, &modInfo); err != nil {
-		return nil, errors.Wrapf(err, "failed to unmarshal JSON: %s", string(output))
+
+	var modInfos []GoModInfo
+	decoder := json.NewDecoder(stdoutBuf)
+	for decoder.More() {
+		var modInfo GoModInfo
+		if err := decoder.Decode(&modInfo); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal JSON: %s", stdoutBuf.String())
+		}
+		modInfos = append(modInfos, modInfo)
+	}
+	if len(modInfos) == 0 {
+		return nil, errors.Errorf("command %v in directory %s did not return any modules", goListCmd.Args, dir)
+	}
+
+	modInfo := modInfos[0]
+	if len(modInfos) > 1 {
+		workspaceModInfo, err := workspaceModuleForDirectory(modInfos, dir)
+		if err != nil {
+			return nil, err
+		}
+		modInfo = workspaceModInfo
 	}
 	if modInfo.Path == "command-line-arguments" {
 		return nil, errors.Errorf("directory %s is not a valid module", dir)
 	}
 	return &modInfo, nil
 }
+
+// workspaceModuleForDirectory returns the module in the provided workspace modules whose directory contains dir. If
+// multiple modules contain the directory, the one with the longest directory is returned. Returns an error if no module
+// contains the directory.
+func workspaceModuleForDirectory(modInfos []GoModInfo, dir string) (GoModInfo, error) {
+	var modInfo GoModInfo
+	longestDirLen := -1
+	for _, currModInfo := range modInfos {
+		relPath, err := relpathNormalizedPaths(currModInfo.Dir, dir)
+		if err != nil {
+			return GoModInfo{}, err
+		}
+		if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(currModInfo.Dir) > longestDirLen {
+			modInfo = currModInfo
+			longestDirLen = len(currModInfo.Dir)
+		}
+	}
+	if longestDirLen == -1 {
+		var moduleDirs []string
+		for _, currModInfo := range modInfos {
+			moduleDirs = append(moduleDirs, currModInfo.Dir)
+		}
+		return GoModInfo{}, errors.Errorf("directory %s is not contained in any module of the workspace (workspace modules: %v)", dir, moduleDirs)
+	}
+	return modInfo, nil
+}
@@ -5,6 +5,7 @@
 package amalgomate
 
 import (
+	"fmt"
 	"io/fs"
 	"os"
 	"os/exec"
@@ -26,10 +27,15 @@ func Test_rewriteImports(t *testing.T) {
 	}()
 
 	for _, tc := range []struct {
-		Name           string
-		GoFiles        []gofiles.GoFileSpec
-		WantFiles      map[string]string
-		RenameInternal bool
+		Name             string
+		GoFiles          []gofiles.GoFileSpec
+		WantFiles        map[string]string
+		WantRemovedFiles []string
+		RenameInternal   bool
+		StripGoGenerate  bool
+		PackageName      string
+		EntrypointName   string
+		CopyTests        bool
 	}{
 		{
 			Name: "rewrites imports within the module",
@@ -94,6 +100,340 @@ func AmalgomatedMain()	{}
 `,
 			},
 		},
+		{
+			Name: "rewrites go:linkname targets and does not rename main packages excluded by build constraints",
+			GoFiles: []gofiles.GoFileSpec{
+				// primary module
+				{
+					RelPath: "go.mod",
+					Src: `module github.com/test-project
+
+require github.com/repackaged-module v1.0.0
+
+replace github.com/repackaged-module => ./repackaged-module-src
+`,
+				},
+				{
+					RelPath: "tools.go",
+					Src: `// +build tools
+package main
+
+import _ "github.com/repackaged-module"
+`,
+				},
+				{
+					RelPath: "internal/github.com/repackaged-module/main.go",
+					Src: `package main
+
+import _ "github.com/repackaged-module/foo"
+
+func main() {}
+`,
+				},
+				{
+					RelPath: "internal/github.com/repackaged-module/main_linux.go",
+					Src: `//go:build linux && !cgo
+
+package main
+`,
+				},
+				{
+					RelPath: "internal/github.com/repackaged-module/foo/foo.go",
+					Src: `package foo
+
+import _ "unsafe"
+
+//go:linkname secret github.com/repackaged-module/bar.secret
+func secret() string
+
+//go:linkname now time.now
+func now() (int64, int32, int64)
+`,
+				},
+				{
+					RelPath: "internal/github.com/repackaged-module/foo/gen.go",
+					Src: `//go:build ignore
+
+package main
+
+import _ "github.com/repackaged-module/foo"
+
+func main() {}
+`,
+				},
+				// repackaged module
+				{
+					RelPath: `repackaged-module-src/go.mod`,
+					Src:     `module github.com/repackaged-module`,
+				},
+				{
+					RelPath: `repackaged-module-src/main.go`,
+					Src: `package main
+
+import _ "github.com/repackaged-module/foo"
+
+func main() {}
+`,
+				},
+				{
+					RelPath: `repackaged-module-src/foo/foo.go`,
+					Src:     `package foo`,
+				},
+				{
+					RelPath: `repackaged-module-src/bar/bar.go`,
+					Src:     `package bar`,
+				},
+			},
+			WantFiles: map[string]string{
+				"internal/github.com/repackaged-module/main_linux.go": `//go:build linux && !cgo
+
+package amalgomated
+`,
+				"internal/github.com/repackaged-module/foo/foo.go": `package foo
+
+import _ "unsafe"
+
+//go:linkname secret github.com/test-project/internal/github.com/repackaged-module/bar.secret
+func secret() string
+
+//go:linkname now time.now
+func now() (int64, int32, int64)
+`,
+				"internal/github.com/repackaged-module/foo/gen.go": `//go:build ignore
+
+package main
+
+import _ "github.com/test-project/internal/github.com/repackaged-module/foo"
+
+func main()	{}
+`,
+			},
+		},
+		{
+			Name: "strips go:generate directives and removes tools-only files",
+			GoFiles: []gofiles.GoFileSpec{
+				// primary module
+				{
+					RelPath: "go.mod",
+					Src: `module github.com/test-project
+
+require github.com/repackaged-module v1.0.0
+
+replace github.com/repackaged-module => ./repackaged-module-src
+`,
+				},
+				{
+					RelPath: "tools.go",
+					Src: `// +build tools
+package main
+
+import _ "github.com/repackaged-module"
+`,
+				},
+				{
+					RelPath: "internal/github.com/repackaged-module/main.go",
+					// directives are not written literally so that they are not run by "go generate" in this repository
+					Src: `package main
+
+import _ "github.com/repackaged-module/foo"
+
+` + goGenerateDirectivePrefix + ` go run ./gen
+
+// main runs the program.
+` + goGenerateDirectivePrefix + ` stringer -type=Kind
+func main() {}
+`,
+				},
+				{
+					RelPath: "internal/github.com/repackaged-module/tools.go",
+					Src: `//go:build tools
+
+package main
+
+import _ "golang.org/x/tools/cmd/stringer"
+`,
+				},
+				// repackaged module
+				{
+					RelPath: `repackaged-module-src/go.mod`,
+					Src:     `module github.com/repackaged-module`,
+				},
+				{
+					RelPath: `repackaged-module-src/main.go`,
+					Src: `package main
+
+import _ "github.com/repackaged-module/foo"
+
+func main() {}
+`,
+				},
+				{
+					RelPath: `repackaged-module-src/foo/foo.go`,
+					Src:     `package foo`,
+				},
+			},
+			WantFiles: map[string]string{
+				"internal/github.com/repackaged-module/main.go": `package amalgomated
+
+import _ "github.com/test-project/internal/github.com/repackaged-module/foo"
+
+// main runs the program.
+func AmalgomatedMain()	{}
+`,
+			},
+			WantRemovedFiles: []string{
+				"internal/github.com/repackaged-module/tools.go",
+			},
+			StripGoGenerate: true,
+		},
+		{
+			Name: "renames main packages and functions using the provided names",
+			GoFiles: []gofiles.GoFileSpec{
+				// primary module
+				{
+					RelPath: "go.mod",
+					Src: `module github.com/test-project
+
+require github.com/repackaged-module v1.0.0
+
+replace github.com/repackaged-module => ./repackaged-module-src
+`,
+				},
+				{
+					RelPath: "tools.go",
+					Src: `// +build tools
+package main
+
+import _ "github.com/repackaged-module"
+`,
+				},
+				{
+					RelPath: "internal/github.com/repackaged-module/main.go",
+					Src: `package main
+
+func main() {
+	AmalgomatedMain()
+}
+
+func AmalgomatedMain() {}
+`,
+				},
+				// repackaged module
+				{
+					RelPath: `repackaged-module-src/go.mod`,
+					Src:     `module github.com/repackaged-module`,
+				},
+				{
+					RelPath: `repackaged-module-src/main.go`,
+					Src: `package main
+
+func main() {
+	AmalgomatedMain()
+}
+
+func AmalgomatedMain() {}
+`,
+				},
+			},
+			WantFiles: map[string]string{
+				"internal/github.com/repackaged-module/main.go": `package inner
+
+func InnerMain() {
+	AmalgomatedMain()
+}
+
+func AmalgomatedMain()	{}
+`,
+			},
+			PackageName:    "inner",
+			EntrypointName: "InnerMain",
+		},
+		{
+			Name: "renames calls to the main function that do not refer to shadowing locals when tests are copied",
+			GoFiles: []gofiles.GoFileSpec{
+				// primary module
+				{
+					RelPath: "go.mod",
+					Src: `module github.com/test-project
+
+require github.com/repackaged-module v1.0.0
+
+replace github.com/repackaged-module => ./repackaged-module-src
+`,
+				},
+				{
+					RelPath: "tools.go",
+					Src: `// +build tools
+package main
+
+import _ "github.com/repackaged-module"
+`,
+				},
+				{
+					RelPath: "internal/github.com/repackaged-module/main.go",
+					Src: `package main
+
+func main() {}
+
+func run(main func()) {
+	main()
+}
+
+func rerun() {
+	main()
+}
+`,
+				},
+				{
+					RelPath: "internal/github.com/repackaged-module/main_test.go",
+					Src: `package main
+
+import "testing"
+
+func TestMain(t *testing.T) {
+	main()
+}
+`,
+				},
+				// repackaged module
+				{
+					RelPath: `repackaged-module-src/go.mod`,
+					Src:     `module github.com/repackaged-module`,
+				},
+				{
+					RelPath: `repackaged-module-src/main.go`,
+					Src: `package main
+
+func main() {}
+`,
+				},
+			},
+			WantFiles: map[string]string{
+				"internal/github.com/repackaged-module/main.go": `package amalgomated
+
+func AmalgomatedMain()	{}
+
+func run(main func()) {
+	main()
+}
+
+func rerun() {
+	AmalgomatedMain()
+}
+`,
+				"internal/github.com/repackaged-module/main_test.go": `package amalgomated
+
+import "testing"
+
+func TestMain(t *testing.T) {
+	AmalgomatedMain()
+}
+`,
+			},
+			PackageName:    "amalgomated",
+			EntrypointName: "AmalgomatedMain",
+			CopyTests:      true,
+		},
 		{
 			Name: "does not rewrite imports to other modules, even if path is within other module",
 			GoFiles: []gofiles.GoFileSpec{
@@ -485,12 +825,24 @@ func AmalgomatedMain()	{}
 			_, err := gofiles.Write(tmpDir, tc.GoFiles)
 			require.NoError(t, err)
 
+			if tc.PackageName == "" {
+				tc.PackageName = amalgomatedPackage
+			}
+			if tc.EntrypointName == "" {
+				tc.EntrypointName = amalgomatedMain
+			}
 			err = rewriteImports(
 				filepath.Join(tmpDir, "internal"),
 				"github.com/repackaged-module",
 				"github.com/test-project/internal",
-				nil,
-				tc.RenameInternal,
+				filepath.Join(tmpDir, "internal"),
+				rewriteOptions{
+					RenameInternal:  tc.RenameInternal,
+					StripGoGenerate: tc.StripGoGenerate,
+					PackageName:     tc.PackageName,
+					EntrypointName:  tc.EntrypointName,
+					CopyTests:       tc.CopyTests,
+				},
 			)
 			require.NoError(t, err)
 
@@ -505,6 +857,9 @@ func AmalgomatedMain()	{}
 				require.NoError(t, err, "Failed to read file %s", k)
 				assert.Equal(t, tc.WantFiles[k], string(gotContent), "Unexpected file content for file %s", k)
 			}
+			for _, k := range tc.WantRemovedFiles {
+				assert.NoFileExists(t, filepath.Join(tmpDir, k))
+			}
 		})
 	}
 }
@@ -1003,6 +1358,7 @@ func Test_copyModuleRecursively(t *testing.T) {
 		SrcFiles       []gofiles.GoFileSpec
 		WantFiles      []string
 		RenameInternal bool
+		CopyTests      bool
 	}{
 		{
 			Name:       "Copies basic module",
@@ -1222,6 +1578,75 @@ func Test_copyModuleRecursively(t *testing.T) {
 				"github.com/test/main.go",
 			},
 		},
+		{
+			Name:       "Does not copy tests when copyTests is false",
+			ModuleName: "github.com/test",
+			SrcFiles: []gofiles.GoFileSpec{
+				{
+					RelPath: "go.mod",
+					Src:     "module github.com/test",
+				},
+				{
+					RelPath: "foo/foo.go",
+					Src:     "package foo",
+				},
+				{
+					RelPath: "foo/foo_test.go",
+					Src:     "package foo",
+				},
+				{
+					RelPath: "foo/testdata/input.txt",
+					Src:     "input",
+				},
+			},
+			WantFiles: []string{
+				"github.com",
+				"github.com/test",
+				"github.com/test/foo",
+				"github.com/test/foo/foo.go",
+				"github.com/test/foo/testdata",
+			},
+		},
+		{
+			Name:           "Copies tests and testdata when copyTests is true",
+			ModuleName:     "github.com/test",
+			RenameInternal: true,
+			CopyTests:      true,
+			SrcFiles: []gofiles.GoFileSpec{
+				{
+					RelPath: "go.mod",
+					Src:     "module github.com/test",
+				},
+				{
+					RelPath: "internal/foo/foo.go",
+					Src:     "package foo",
+				},
+				{
+					RelPath: "internal/foo/foo_test.go",
+					Src:     "package foo",
+				},
+				{
+					RelPath: "internal/foo/testdata/input.txt",
+					Src:     "input",
+				},
+				{
+					RelPath: "internal/foo/testdata/src/main.go",
+					Src:     "package main",
+				},
+			},
+			WantFiles: []string{
+				"github.com",
+				"github.com/test",
+				"github.com/test/internal_",
+				"github.com/test/internal_/foo",
+				"github.com/test/internal_/foo/foo.go",
+				"github.com/test/internal_/foo/foo_test.go",
+				"github.com/test/internal_/foo/testdata",
+				"github.com/test/internal_/foo/testdata/input.txt",
+				"github.com/test/internal_/foo/testdata/src",
+				"github.com/test/internal_/foo/testdata/src/main.go",
+			},
+		},
 	} {
 		t.Run(tc.Name, func(t *testing.T) {
 			tmpDir := t.TempDir()
@@ -1234,7 +1659,10 @@ func Test_copyModuleRecursively(t *testing.T) {
 			_, err = gofiles.Write(srcDir, tc.SrcFiles)
 			require.NoError(t, err)
 
-			err = copyModuleRecursively(tc.ModuleName, srcDir, dstDir, tc.RenameInternal)
+			err = copyModuleRecursively(tc.ModuleName, srcDir, dstDir, copyOptions{
+				RenameInternal: tc.RenameInternal,
+				CopyTests:      tc.CopyTests,
+			})
 			require.NoError(t, err)
 
 			gotFilePaths, err := allFilePaths(dstDir)
@@ -1253,6 +1681,7 @@ func Test_copiesEmbedFiles(t *testing.T) {
 		SrcFiles       []gofiles.GoFileSpec
 		WantFiles      []string
 		RenameInternal bool
+		Build          buildOptions
 	}{
 		{
 			Name:       "Copies embed files in root package",
@@ -1475,6 +1904,95 @@ var content string
 				"github.com/test/main.go",
 			},
 		},
+		{
+			Name:       "copies embed files for all target platforms and build tags",
+			ModuleName: "github.com/test",
+			SrcFiles: []gofiles.GoFileSpec{
+				{
+					RelPath: "go.mod",
+					Src:     "module github.com/test\n\ngo 1.21\n",
+				},
+				{
+					RelPath: "main.go",
+					Src:     "package main\n\nfunc main() {}\n",
+				},
+				{
+					RelPath: "assets/assets_linux.go",
+					Src: `package assets
+
+import _ "embed"
+
+//go:embed linux.txt
+var content string
+`,
+				},
+				{
+					RelPath: "assets/assets_windows.go",
+					Src: `package assets
+
+import _ "embed"
+
+//go:embed windows.txt
+var content string
+`,
+				},
+				{
+					RelPath: "assets/assets_plan9.go",
+					Src: `package assets
+
+import _ "embed"
+
+//go:embed plan9.txt
+var content string
+`,
+				},
+				{
+					RelPath: "assets/extra.go",
+					Src: `//go:build extra
+
+package assets
+
+import _ "embed"
+
+//go:embed extra.txt
+var extra string
+`,
+				},
+				{
+					RelPath: "assets/linux.txt",
+					Src:     "linux",
+				},
+				{
+					RelPath: "assets/windows.txt",
+					Src:     "windows",
+				},
+				{
+					RelPath: "assets/plan9.txt",
+					Src:     "plan9",
+				},
+				{
+					RelPath: "assets/extra.txt",
+					Src:     "extra",
+				},
+			},
+			WantFiles: []string{
+				"github.com",
+				"github.com/test",
+				"github.com/test/assets",
+				"github.com/test/assets/assets_linux.go",
+				"github.com/test/assets/assets_plan9.go",
+				"github.com/test/assets/assets_windows.go",
+				"github.com/test/assets/extra.go",
+				"github.com/test/assets/extra.txt",
+				"github.com/test/assets/linux.txt",
+				"github.com/test/assets/windows.txt",
+				"github.com/test/main.go",
+			},
+			Build: buildOptions{
+				Tags:      []string{"extra"},
+				Platforms: []string{"linux/amd64", "windows/arm64"},
+			},
+		},
 	} {
 		t.Run(tc.Name, func(t *testing.T) {
 			tmpDir := t.TempDir()
@@ -1493,7 +2011,10 @@ var content string
 			err = goModTidy.Run()
 			require.NoError(t, err)
 
-			err = copyModuleRecursively(tc.ModuleName, srcDir, dstDir, tc.RenameInternal)
+			err = copyModuleRecursively(tc.ModuleName, srcDir, dstDir, copyOptions{
+				RenameInternal: tc.RenameInternal,
+				Build:          tc.Build,
+			})
 			require.NoError(t, err)
 
 			gotFilePaths, err := allFilePaths(dstDir)
@@ -1506,6 +2027,47 @@ var content string
 	}
 }
 
+// Test_copyModuleRecursivelyEmbedErrors verifies that copyModuleRecursively returns an error that identifies the package
+// and its go:embed patterns if the patterns cannot be resolved unless LenientEmbed is true.
+func Test_copyModuleRecursivelyEmbedErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcDir := filepath.Join(tmpDir, "srcDir")
+	_, err := gofiles.Write(srcDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "go.mod",
+			Src:     "module github.com/test",
+		},
+		{
+			RelPath: "main.go",
+			Src: `package main
+
+import _ "embed"
+
+//go:embed missing.txt
+var content string
+
+func main() {}
+`,
+		},
+	})
+	require.NoError(t, err)
+
+	dstDir := filepath.Join(tmpDir, "dstDir")
+	require.NoError(t, os.Mkdir(dstDir, 0755))
+	err = copyModuleRecursively("github.com/test", srcDir, dstDir, copyOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("failed to resolve go:embed patterns [missing.txt] of package github.com/test in directory %s: ", srcDir))
+	assert.Contains(t, err.Error(), "missing.txt: no matching files found")
+
+	lenientDstDir := filepath.Join(tmpDir, "lenientDstDir")
+	require.NoError(t, os.Mkdir(lenientDstDir, 0755))
+	err = copyModuleRecursively("github.com/test", srcDir, lenientDstDir, copyOptions{
+		LenientEmbed: true,
+	})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(lenientDstDir, "github.com", "test", "main.go"))
+}
+
 func allFilePaths(dir string) ([]string, error) {
 	var paths []string
 	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
@@ -0,0 +1,122 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// cacheDirEnvVar is the environment variable that specifies the directory in which amalgomate caches modules
+	// downloaded for entries that specify a version. If unset, a directory in the user cache directory is used.
+	cacheDirEnvVar = "AMALGOMATE_CACHE"
+
+	pinnedModulePath = "amalgomate-pinned-module"
+)
+
+// pinnedModuleDir returns the directory of a module whose only requirement is the module that provides mainPkg at the
+// specified version. The module and its dependencies are downloaded into a module cache that is private to amalgomate,
+// so the module does not need to be a dependency of the output module and multiple versions of the same module can be
+// repackaged. Modules are downloaded using the GOPROXY and GOSUMDB settings of the environment. The module directory is
+// created once for every main package and version, and subsequent calls return the existing directory: entries in the
+// cache never expire and the modules in it are only verified when they are downloaded, so the cache directory must be
+// removed to download them again.
+func pinnedModuleDir(mainPkg, version string) (string, error) {
+	cacheDir, err := amalgomateCacheDir()
+	if err != nil {
+		return "", err
+	}
+	fetchRootDir := filepath.Join(cacheDir, "pinned")
+	if err := os.MkdirAll(fetchRootDir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory %s", fetchRootDir)
+	}
+
+	hash := sha256.Sum256([]byte(mainPkg + "@" + version))
+	moduleDir := filepath.Join(fetchRootDir, hex.EncodeToString(hash[:])[:16])
+	if _, err := os.Stat(moduleDir); err == nil {
+		return moduleDir, nil
+	}
+
+	// create the module in a temporary directory and move it into place only after the download succeeds so that an
+	// incomplete module is never returned
+	tmpDir, err := os.MkdirTemp(fetchRootDir, "tmp-")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create temporary directory in %s", fetchRootDir)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	goModPath := filepath.Join(tmpDir, "go.mod")
+	if err := os.WriteFile(goModPath, []byte("module "+pinnedModulePath+"\n"), 0644); err != nil {
+		return "", errors.Wrapf(err, "failed to write %s", goModPath)
+	}
+
+	goGetCmd := exec.Command("go", "get", mainPkg+"@"+version)
+	goGetCmd.Dir = tmpDir
+	goGetCmd.Env = pinnedModuleEnv(cacheDir)
+	if output, err := goGetCmd.CombinedOutput(); err != nil {
+		return "", errors.Wrapf(err, "failed to download %s@%s using command %v. Output: %s", mainPkg, version, goGetCmd.Args, string(output))
+	}
+
+	if err := os.Rename(tmpDir, moduleDir); err != nil {
+		if _, statErr := os.Stat(moduleDir); statErr == nil {
+			// module directory was created concurrently
+			return moduleDir, nil
+		}
+		return "", errors.Wrapf(err, "failed to move %s to %s", tmpDir, moduleDir)
+	}
+	return moduleDir, nil
+}
+
+// goEnvForDir returns the environment that should be used to run Go commands in the provided directory. Returns nil
+// (which indicates that the environment of the current process should be used) unless the directory is in the
+// amalgomate cache directory, in which case the environment uses the private module cache.
+func goEnvForDir(dir string) []string {
+	cacheDir, err := amalgomateCacheDir()
+	if err != nil {
+		return nil
+	}
+	if relPath, err := filepath.Rel(cacheDir, dir); err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	return pinnedModuleEnv(cacheDir)
+}
+
+// pinnedModuleEnv returns the environment of the current process modified to use the module cache in the provided
+// amalgomate cache directory. Module mode is always used (and workspaces are disabled) because the modules in the cache
+// directory are not vendored and are not part of a workspace. The module cache is made writable so that it can be
+// removed without changing permissions. The flags are appended to the GOFLAGS of the environment so that other flags
+// (such as -tags) are kept.
+func pinnedModuleEnv(cacheDir string) []string {
+	goFlags := "-mod=mod -modcacherw"
+	if envGoFlags := strings.TrimSpace(os.Getenv("GOFLAGS")); envGoFlags != "" {
+		goFlags = envGoFlags + " " + goFlags
+	}
+	return append(os.Environ(),
+		"GOMODCACHE="+filepath.Join(cacheDir, "mod"),
+		"GOFLAGS="+goFlags,
+		"GOWORK=off",
+	)
+}
+
+// amalgomateCacheDir returns the directory in which amalgomate caches downloaded modules.
+func amalgomateCacheDir() (string, error) {
+	if cacheDir := os.Getenv(cacheDirEnvVar); cacheDir != "" {
+		return filepath.Abs(cacheDir)
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to determine user cache directory (set %s to specify the cache directory)", cacheDirEnvVar)
+	}
+	return filepath.Join(userCacheDir, "amalgomate"), nil
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nmiyake/pkg/gofiles"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/zip"
+)
+
+// TestRunPinnedVersions verifies that entries that specify a version are downloaded using the module proxy into the
+// private module cache and that multiple versions of the same module can be amalgomated into a module that does not
+// require the module.
+func TestRunPinnedVersions(t *testing.T) {
+	tmpDir := t.TempDir()
+	proxyDir := filepath.Join(tmpDir, "proxy")
+	for _, version := range []string{"v1.0.0", "v1.1.0"} {
+		writeProxyModule(t, proxyDir, "example.com/tool", version, []gofiles.GoFileSpec{
+			{
+				RelPath: "go.mod",
+				Src:     "module example.com/tool\n",
+			},
+			{
+				RelPath: "cmd/tool/main.go",
+				Src: `package main
+
+import (
+	"fmt"
+
+	"example.com/tool/internal/version"
+)
+
+func main() {
+	fmt.Println(version.Version)
+}
+`,
+			},
+			{
+				RelPath: "internal/version/version.go",
+				Src:     fmt.Sprintf("package version\n\nconst Version = %q\n", version),
+			},
+		})
+	}
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	t.Setenv(cacheDirEnvVar, cacheDir)
+	t.Setenv("GOPROXY", "file://"+filepath.ToSlash(proxyDir))
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GOFLAGS", "-mod=mod")
+
+	projectDir := filepath.Join(tmpDir, "project")
+	_, err := gofiles.Write(projectDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "go.mod",
+			Src:     "module example.com/project\n\ngo 1.21\n",
+		},
+	})
+	require.NoError(t, err)
+	outputDir := filepath.Join(projectDir, "amalgomated")
+
+	err = Run(Config{
+		Pkgs: map[string]SrcPkg{
+			"tool10": {
+				MainPkg: "example.com/tool/cmd/tool",
+				Version: "v1.0.0",
+			},
+			"tool11": {
+				MainPkg: "example.com/tool/cmd/tool",
+				Version: "v1.1.0",
+			},
+		},
+	}, outputDir, "main")
+	require.NoError(t, err)
+
+	for _, version := range []string{"v1.0.0", "v1.1.0"} {
+		versionFile := filepath.Join(outputDir, "internal", version, "example.com", "tool", "internal", "version", "version.go")
+		assert.FileExists(t, versionFile)
+
+		moduleDir, err := pinnedModuleDir("example.com/tool/cmd/tool", version)
+		require.NoError(t, err)
+		modInfo, err := moduleInfoForPackage("example.com/tool/cmd/tool", moduleDir)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(cacheDir, "mod", "example.com", "tool@"+version), modInfo.Dir)
+	}
+
+	goModContent, err := os.ReadFile(filepath.Join(projectDir, "go.mod"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(goModContent), "example.com/tool", "project module should not require the pinned module")
+
+	for name, wantVersion := range map[string]string{"tool10": "v1.0.0", "tool11": "v1.1.0"} {
+		goRunCmd := exec.Command("go", "run", "./amalgomated", name)
+		goRunCmd.Dir = projectDir
+		output, err := goRunCmd.CombinedOutput()
+		require.NoError(t, err, "Output: %s", string(output))
+		assert.Equal(t, wantVersion, strings.TrimSpace(string(output)))
+	}
+}
+
+func Test_pinnedModuleEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		goFlags string
+		want    string
+	}{
+		{
+			name: "sets flags",
+			want: "GOFLAGS=-mod=mod -modcacherw",
+		},
+		{
+			name:    "appends flags to flags of environment",
+			goFlags: "-tags=integration",
+			want:    "GOFLAGS=-tags=integration -mod=mod -modcacherw",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("GOFLAGS", tc.goFlags)
+			env := pinnedModuleEnv(t.TempDir())
+			// the last value of a variable takes precedence
+			var got string
+			for _, kv := range env {
+				if strings.HasPrefix(kv, "GOFLAGS=") {
+					got = kv
+				}
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+// writeProxyModule writes the provided files as the specified version of the specified module into proxyDir, which is
+// structured as a module proxy that can be used with a "file://" GOPROXY URL.
+func writeProxyModule(t *testing.T, proxyDir, modulePath, version string, files []gofiles.GoFileSpec) {
+	srcDir := t.TempDir()
+	_, err := gofiles.Write(srcDir, files)
+	require.NoError(t, err)
+
+	versionDir := filepath.Join(proxyDir, modulePath, "@v")
+	require.NoError(t, os.MkdirAll(versionDir, 0755))
+
+	zipFile, err := os.Create(filepath.Join(versionDir, version+".zip"))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, zipFile.Close())
+	}()
+	require.NoError(t, zip.CreateFromDir(zipFile, module.Version{Path: modulePath, Version: version}, srcDir))
+
+	goModContent, err := os.ReadFile(filepath.Join(srcDir, "go.mod"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(versionDir, version+".mod"), goModContent, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(versionDir, version+".info"), []byte(fmt.Sprintf(`{"Version":%q,"Time":"2026-01-01T00:00:00Z"}`, version)), 0644))
+
+	listFile, err := os.OpenFile(filepath.Join(versionDir, "list"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = fmt.Fprintln(listFile, version)
+	require.NoError(t, err)
+	require.NoError(t, listFile.Close())
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// RunTests runs "go test" on the repackaged modules of the specified programs in the provided output directory, which
+// must contain the output of a previous call to Run with the same configuration. Programs can be specified using their
+// name or an alias. If no programs are specified, the tests of all of the programs that set CopyTests are run. Returns
+// an error if a specified program does not set CopyTests. The provided arguments are passed to "go test" before the
+// package patterns, and the output of the command is written to the standard output and error streams.
+func RunTests(cfg Config, outputDir string, programs []string, goTestArgs []string) error {
+	if err := cfg.Validate(); err != nil {
+		return errors.Wrapf(err, "configuration is not valid")
+	}
+
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to convert %s into absolute path", outputDir)
+	}
+
+	names, err := programsWithTests(cfg, programs)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.Errorf("no programs in the configuration copy tests")
+	}
+
+	projectModuleInfo, err := moduleInfoForDirectory(outputDir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to determine module for directory %s", outputDir)
+	}
+
+	amalgomateDirName := internalDir
+	if cfg.AmalgomateDir != "" {
+		amalgomateDirName = cfg.AmalgomateDir
+	}
+
+	var patterns []string
+	processedSources := make(map[string]bool)
+	for _, name := range names {
+		pkg := cfg.Pkgs[name]
+		if processedSources[pkg.source()] {
+			continue
+		}
+		processedSources[pkg.source()] = true
+
+		mainPkg, resolveDir, err := resolveMainPkg(pkg, outputDir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve main package for %s", name)
+		}
+		modInfo, err := moduleInfoForPackage(mainPkg, resolveDir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to determine module for main package")
+		}
+
+		repackagedModuleDir := filepath.Join(outputDir, amalgomateDirName, pkg.Version, modInfo.Path)
+		if _, err := os.Stat(repackagedModuleDir); err != nil {
+			return errors.Wrapf(err, "repackaged module for %s not found: amalgomate must be run before running tests", name)
+		}
+		relPath, err := relpathNormalizedPaths(projectModuleInfo.Dir, repackagedModuleDir)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, "./"+filepath.ToSlash(relPath)+"/...")
+	}
+
+	goTestCmd := exec.Command("go", append(append([]string{"test"}, goTestArgs...), patterns...)...)
+	goTestCmd.Dir = projectModuleInfo.Dir
+	goTestCmd.Stdout = os.Stdout
+	goTestCmd.Stderr = os.Stderr
+	if err := goTestCmd.Run(); err != nil {
+		return errors.Wrapf(err, "command %v failed", goTestCmd.Args)
+	}
+	return nil
+}
+
+// programsWithTests returns the names of the entries in cfg.Pkgs that correspond to the provided program names or
+// aliases in sorted order. If no programs are provided, returns the names of all of the entries that set CopyTests.
+func programsWithTests(cfg Config, programs []string) ([]string, error) {
+	if len(programs) == 0 {
+		var names []string
+		for _, name := range sortedKeys(cfg.Pkgs) {
+			if cfg.Pkgs[name].CopyTests {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+
+	nameForProgram := make(map[string]string)
+	for name, pkg := range cfg.Pkgs {
+		nameForProgram[name] = name
+		for _, alias := range pkg.Aliases {
+			nameForProgram[alias] = name
+		}
+	}
+	selected := make(map[string]SrcPkg)
+	for _, program := range programs {
+		name, ok := nameForProgram[program]
+		if !ok {
+			return nil, errors.Errorf("program %s is not defined in the configuration", program)
+		}
+		if !cfg.Pkgs[name].CopyTests {
+			return nil, errors.Errorf("program %s does not copy tests: set copy-tests to true in its configuration and run amalgomate again", program)
+		}
+		selected[name] = cfg.Pkgs[name]
+	}
+	return sortedKeys(selected), nil
+}
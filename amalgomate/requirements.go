@@ -0,0 +1,300 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+	"golang.org/x/tools/go/packages"
+)
+
+// repackagedModule describes a module that was repackaged for a program.
+type repackagedModule struct {
+	// Program is the name of the program in the configuration.
+	Program string
+	// Path is the module path.
+	Path string
+	// Dir is the directory from which the module was copied.
+	Dir string
+	// RepackagedDir is the directory into which the module was repackaged.
+	RepackagedDir string
+}
+
+type requirementIssueKind int
+
+const (
+	// requirementMissing indicates that the host module does not require a module required by a repackaged module.
+	requirementMissing requirementIssueKind = iota
+	// requirementDowngrade indicates that the host module requires an older version of a module than the version
+	// required by a repackaged module.
+	requirementDowngrade
+	// requirementConflict indicates that repackaged modules require different versions of the same module, so at least
+	// one of them will be built using a version other than the one it requires.
+	requirementConflict
+)
+
+// requirementIssue describes a requirement of a repackaged module that is not satisfied by the host module.
+type requirementIssue struct {
+	Kind requirementIssueKind
+	// ModulePath is the path of the required module.
+	ModulePath string
+	// Version is the minimum version of the module that satisfies the requirements of all of the repackaged modules.
+	Version string
+	// HostVersion is the version of the module required by the host module. Empty if the host module does not require
+	// the module.
+	HostVersion string
+	// Requirements maps the programs whose modules require the module to the version that they require.
+	Requirements map[string]string
+	// Indirect is true if the module is an indirect requirement of all of the repackaged modules that require it.
+	Indirect bool
+}
+
+func (i requirementIssue) String() string {
+	switch i.Kind {
+	case requirementMissing:
+		return fmt.Sprintf("%s %s is required by %s but is not required by the host module", i.ModulePath, i.Version, i.requirementsString())
+	case requirementDowngrade:
+		return fmt.Sprintf("%s is required at %s by the host module, which is a downgrade from the version required by %s", i.ModulePath, i.HostVersion, i.requirementsString())
+	default:
+		return fmt.Sprintf("%s is required at different versions by %s: %s will be used", i.ModulePath, i.requirementsString(), i.Version)
+	}
+}
+
+func (i requirementIssue) requirementsString() string {
+	var parts []string
+	for _, program := range sortedKeys(i.Requirements) {
+		parts = append(parts, fmt.Sprintf("%s (%s)", program, i.Requirements[program]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkRequirements compares the requirements in the go.mod files of the provided repackaged modules with the
+// requirements of the host module defined by the go.mod file at hostGoModPath and returns the issues that were found.
+// Repackaged modules that do not have a go.mod file (for example, modules copied from a vendor directory) are skipped.
+// Requirements on the host module itself are ignored. Issues are sorted by module path and then by kind.
+//
+// usedModules maps the source directories of repackaged modules to the paths of the modules that provide the packages
+// imported by their non-test code (see usedModulePaths). Only the requirements on those modules are checked. All of
+// the requirements of a repackaged module whose directory is not in usedModules are checked.
+func checkRequirements(hostGoModPath string, modules []repackagedModule, usedModules map[string]map[string]bool) ([]requirementIssue, error) {
+	hostGoMod, err := readGoModFile(hostGoModPath)
+	if err != nil {
+		return nil, err
+	}
+	hostVersions := make(map[string]string)
+	for _, req := range hostGoMod.Require {
+		hostVersions[req.Mod.Path] = req.Mod.Version
+	}
+
+	// required module path -> program -> version
+	wrappedRequirements := make(map[string]map[string]string)
+	// required module path -> whether it is only an indirect requirement
+	indirectRequirements := make(map[string]bool)
+	visitedModuleDirs := make(map[string]bool)
+	for _, module := range modules {
+		if visitedModuleDirs[module.Dir] {
+			continue
+		}
+		visitedModuleDirs[module.Dir] = true
+
+		goMod, err := readRepackagedGoModFile(module.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if goMod == nil {
+			continue
+		}
+		used, filterUsed := usedModules[module.Dir]
+		for _, req := range goMod.Require {
+			if hostGoMod.Module != nil && req.Mod.Path == hostGoMod.Module.Mod.Path {
+				continue
+			}
+			if filterUsed && !used[req.Mod.Path] {
+				// requirements that are only needed by tests (or by packages that are not built) are not needed by
+				// the repackaged code
+				continue
+			}
+			if wrappedRequirements[req.Mod.Path] == nil {
+				wrappedRequirements[req.Mod.Path] = make(map[string]string)
+				indirectRequirements[req.Mod.Path] = true
+			}
+			wrappedRequirements[req.Mod.Path][module.Program] = req.Mod.Version
+			indirectRequirements[req.Mod.Path] = indirectRequirements[req.Mod.Path] && req.Indirect
+		}
+	}
+
+	var issues []requirementIssue
+	for _, modulePath := range sortedKeys(wrappedRequirements) {
+		requirements := wrappedRequirements[modulePath]
+		maxVersion := ""
+		conflict := false
+		for _, version := range requirements {
+			if maxVersion != "" && semver.Compare(version, maxVersion) != 0 {
+				conflict = true
+			}
+			if maxVersion == "" || semver.Compare(version, maxVersion) > 0 {
+				maxVersion = version
+			}
+		}
+		newIssue := func(kind requirementIssueKind) requirementIssue {
+			return requirementIssue{
+				Kind:         kind,
+				ModulePath:   modulePath,
+				Version:      maxVersion,
+				HostVersion:  hostVersions[modulePath],
+				Requirements: requirements,
+				Indirect:     indirectRequirements[modulePath],
+			}
+		}
+
+		if hostVersion, ok := hostVersions[modulePath]; !ok {
+			issues = append(issues, newIssue(requirementMissing))
+		} else if semver.Compare(hostVersion, maxVersion) < 0 {
+			issues = append(issues, newIssue(requirementDowngrade))
+		}
+		if conflict {
+			issues = append(issues, newIssue(requirementConflict))
+		}
+	}
+	return issues, nil
+}
+
+// updateHostRequirements updates the go.mod file at hostGoModPath so that it requires the minimum versions of modules
+// needed to resolve the provided missing and downgrade issues. Missing requirements are added as indirect requirements
+// if they are indirect requirements of all of the repackaged modules that require them. Returns the issues that were
+// resolved.
+func updateHostRequirements(hostGoModPath string, issues []requirementIssue) ([]requirementIssue, error) {
+	hostGoMod, err := readGoModFile(hostGoModPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved []requirementIssue
+	for _, issue := range issues {
+		switch issue.Kind {
+		case requirementMissing:
+			hostGoMod.AddNewRequire(issue.ModulePath, issue.Version, issue.Indirect)
+		case requirementDowngrade:
+			if err := hostGoMod.AddRequire(issue.ModulePath, issue.Version); err != nil {
+				return nil, errors.Wrapf(err, "failed to update requirement for %s in %s", issue.ModulePath, hostGoModPath)
+			}
+		default:
+			continue
+		}
+		resolved = append(resolved, issue)
+	}
+	if len(resolved) == 0 {
+		return nil, nil
+	}
+
+	// rewrite the requirements so that they are sorted and direct and indirect requirements are in separate blocks
+	hostGoMod.SetRequireSeparateIndirect(hostGoMod.Require)
+	hostGoMod.Cleanup()
+	content, err := hostGoMod.Format()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to format %s", hostGoModPath)
+	}
+	if err := os.WriteFile(hostGoModPath, content, 0644); err != nil {
+		return nil, errors.Wrapf(err, "failed to write %s", hostGoModPath)
+	}
+	return resolved, nil
+}
+
+// usedModulePaths returns the paths of the modules that provide the packages that are imported (directly or
+// transitively) by the non-test packages of the module in moduleDir when they are built using the provided build
+// options. Returns an error if the packages cannot be loaded.
+func usedModulePaths(moduleDir string, build buildOptions) (map[string]bool, error) {
+	used := make(map[string]bool)
+	for _, loadConfig := range build.loadConfigs(moduleDir, packages.NeedName|packages.NeedImports|packages.NeedDeps|packages.NeedModule) {
+		pkgs, err := packages.Load(loadConfig, "./...")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load packages in directory %s", moduleDir)
+		}
+		var loadErr error
+		packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+			if len(pkg.Errors) > 0 && loadErr == nil {
+				loadErr = errors.Errorf("failed to load package %s: %v", pkg.PkgPath, pkg.Errors[0])
+			}
+			if pkg.Module != nil {
+				used[pkg.Module.Path] = true
+			}
+		})
+		if loadErr != nil {
+			return nil, loadErr
+		}
+	}
+	return used, nil
+}
+
+// reconcileRequirements checks the requirements of the provided repackaged modules against the requirements of the
+// host module whose root directory is hostModuleDir and writes the issues that were found to the standard error
+// stream. Only the requirements on modules that provide packages imported by the non-test code of a repackaged module
+// (when built using the provided build options) are checked. If update is true, the go.mod file of the host module is
+// updated to resolve missing requirements and downgrades, and a message that instructs the user to run "go mod tidy"
+// is written if it was changed.
+func reconcileRequirements(hostModuleDir string, modules []repackagedModule, update bool, build buildOptions) error {
+	usedModules := make(map[string]map[string]bool)
+	for _, module := range modules {
+		if _, ok := usedModules[module.Dir]; ok {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(module.Dir, "go.mod")); err != nil {
+			// requirements of modules without a go.mod file are not checked
+			continue
+		}
+		used, err := usedModulePaths(module.Dir, build)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "amalgomate: warning: checking all requirements of %s because the modules used by its packages could not be determined: %v\n", module.Path, err)
+			continue
+		}
+		usedModules[module.Dir] = used
+	}
+
+	hostGoModPath := filepath.Join(hostModuleDir, "go.mod")
+	issues, err := checkRequirements(hostGoModPath, modules, usedModules)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check requirements of repackaged modules")
+	}
+
+	resolved := make(map[string]bool)
+	if update {
+		resolvedIssues, err := updateHostRequirements(hostGoModPath, issues)
+		if err != nil {
+			return errors.Wrapf(err, "failed to update requirements of host module")
+		}
+		for _, issue := range resolvedIssues {
+			resolved[issue.ModulePath] = true
+			_, _ = fmt.Fprintf(os.Stderr, "amalgomate: updated requirement %s %s in %s\n", issue.ModulePath, issue.Version, hostGoModPath)
+		}
+		if len(resolvedIssues) > 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "amalgomate: run \"go mod tidy\" in %s to update its go.sum file and the requirements of the packages it builds\n", hostModuleDir)
+		}
+	}
+	for _, issue := range issues {
+		if issue.Kind != requirementConflict && resolved[issue.ModulePath] {
+			continue
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "amalgomate: warning: %s\n", issue)
+	}
+	return nil
+}
+
+func readGoModFile(goModPath string) (*modfile.File, error) {
+	content, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", goModPath)
+	}
+	goMod, err := modfile.Parse(goModPath, content, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", goModPath)
+	}
+	return goMod, nil
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nmiyake/pkg/gofiles"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAndUpdateRequirements(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "host/go.mod",
+			Src: `module example.com/host
+
+go 1.21
+
+require (
+	example.com/current v1.2.0
+	example.com/old v1.0.0
+)
+`,
+		},
+		{
+			RelPath: "foo/go.mod",
+			Src: `module example.com/foo
+
+go 1.21
+
+require (
+	example.com/current v1.1.0
+	example.com/host v0.1.0
+	example.com/missing v1.0.0
+	example.com/old v1.1.0
+	example.com/shared v1.0.0 // indirect
+)
+`,
+		},
+		{
+			RelPath: "bar/go.mod",
+			Src: `module example.com/bar
+
+go 1.21
+
+require example.com/shared v1.3.0 // indirect
+`,
+		},
+	})
+	require.NoError(t, err)
+
+	hostGoModPath := filepath.Join(tmpDir, "host", "go.mod")
+	modules := []repackagedModule{
+		{Program: "foo", Path: "example.com/foo", Dir: filepath.Join(tmpDir, "foo")},
+		{Program: "bar", Path: "example.com/bar", Dir: filepath.Join(tmpDir, "bar")},
+		// modules without a go.mod file are skipped
+		{Program: "vendored", Path: "example.com/vendored", Dir: filepath.Join(tmpDir, "vendored")},
+	}
+
+	issues, err := checkRequirements(hostGoModPath, modules, nil)
+	require.NoError(t, err)
+	var gotIssues []string
+	for _, issue := range issues {
+		gotIssues = append(gotIssues, issue.String())
+	}
+	assert.Equal(t, []string{
+		"example.com/missing v1.0.0 is required by foo (v1.0.0) but is not required by the host module",
+		"example.com/old is required at v1.0.0 by the host module, which is a downgrade from the version required by foo (v1.1.0)",
+		"example.com/shared v1.3.0 is required by bar (v1.3.0), foo (v1.0.0) but is not required by the host module",
+		"example.com/shared is required at different versions by bar (v1.3.0), foo (v1.0.0): v1.3.0 will be used",
+	}, gotIssues)
+
+	resolved, err := updateHostRequirements(hostGoModPath, issues)
+	require.NoError(t, err)
+	assert.Len(t, resolved, 3)
+
+	gotGoMod, err := os.ReadFile(hostGoModPath)
+	require.NoError(t, err)
+	assert.Equal(t, `module example.com/host
+
+go 1.21
+
+require (
+	example.com/current v1.2.0
+	example.com/missing v1.0.0
+	example.com/old v1.1.0
+)
+
+require example.com/shared v1.3.0 // indirect
+`, string(gotGoMod))
+
+	issues, err = checkRequirements(hostGoModPath, modules, nil)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, requirementConflict, issues[0].Kind)
+
+	// only the requirements on modules used by the non-test code are checked
+	issues, err = checkRequirements(hostGoModPath, modules, map[string]map[string]bool{
+		filepath.Join(tmpDir, "foo"): {"example.com/shared": true},
+		filepath.Join(tmpDir, "bar"): {},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestUsedModulePaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := gofiles.Write(tmpDir, []gofiles.GoFileSpec{
+		{
+			RelPath: "foo/go.mod",
+			Src: `module example.com/foo
+
+go 1.21
+
+require (
+	example.com/dep v1.0.0
+	example.com/indirect v1.0.0 // indirect
+	example.com/testonly v1.0.0
+)
+
+replace (
+	example.com/dep => ../dep
+	example.com/indirect => ../indirect
+	example.com/testonly => ../testonly
+)
+`,
+		},
+		{
+			RelPath: "foo/main.go",
+			Src: `package main
+
+import "example.com/dep"
+
+func main() {
+	dep.Run()
+}
+`,
+		},
+		{
+			RelPath: "foo/main_test.go",
+			Src: `package main
+
+import (
+	"testing"
+
+	"example.com/testonly"
+)
+
+func TestMain(t *testing.T) {
+	testonly.Run()
+}
+`,
+		},
+		{
+			RelPath: "dep/go.mod",
+			Src:     "module example.com/dep\n\ngo 1.21\n\nrequire example.com/indirect v1.0.0\n",
+		},
+		{
+			RelPath: "dep/dep.go",
+			Src: `package dep
+
+import "example.com/indirect"
+
+func Run() {
+	indirect.Run()
+}
+`,
+		},
+		{
+			RelPath: "indirect/go.mod",
+			Src:     "module example.com/indirect\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "indirect/indirect.go",
+			Src:     "package indirect\n\nfunc Run() {}\n",
+		},
+		{
+			RelPath: "testonly/go.mod",
+			Src:     "module example.com/testonly\n\ngo 1.21\n",
+		},
+		{
+			RelPath: "testonly/testonly.go",
+			Src:     "package testonly\n\nfunc Run() {}\n",
+		},
+	})
+	require.NoError(t, err)
+
+	used, err := usedModulePaths(filepath.Join(tmpDir, "foo"), buildOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"example.com/dep":      true,
+		"example.com/foo":      true,
+		"example.com/indirect": true,
+	}, used)
+}
@@ -0,0 +1,562 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+const (
+	// resetPackage is the name of the package (and of its directory in the repackaged module) that restores the
+	// package-level variables of a repackaged module.
+	resetPackage = "amalgomated_reset"
+	// flagPackageDir is the name of the directory into which the "flag" package is repackaged.
+	flagPackageDir = "amalgomated_flag"
+)
+
+var resetPackageTemplate = template.Must(template.New("reset").Parse(`// Code generated by amalgomate; DO NOT EDIT.
+
+// Package amalgomated_reset restores the package-level state of a repackaged module so that its program can be run
+// multiple times in the same process.
+package amalgomated_reset
+
+import (
+{{- if .}}
+	flag "{{.}}"
+{{- end}}
+	"sort"
+)
+
+// Var restores a package-level variable (or the variables declared by one multi-value initializer). Rank is the
+// position of the variable in the initialization order of its package.
+type Var struct {
+	Rank  int
+	Reset func()
+}
+
+type registration struct {
+	pkg      string
+	runInits func()
+	vars     []Var
+}
+
+var (
+	registrations []registration
+	// used is true once Reset has been called. The state of the module is the initial state until its first program is
+	// run, so the first call does nothing (and does not discard the changes made by the init functions that ran when the
+	// process started) unless the shared state of the repackaged "flag" package was reset by another module.
+	used bool
+)
+
+// Register registers the function that runs the init functions of a file of the package with the provided import path
+// again (which does nothing unless the module was generated with rerun-inits) and the functions that restore the
+// package-level variables declared in the file. The files register their functions when they are initialized, so the
+// functions are registered in initialization order.
+func Register(pkg string, runInits func(), vars ...Var) {
+	registrations = append(registrations, registration{pkg: pkg, runInits: runInits, vars: vars})
+}
+
+// Reset restores the package-level variables of the packages of the module to the values of their initializers and
+// runs their registered init functions. As with program initialization, the packages are processed one at a time and
+// the variables of all of the files of a package are restored (in initialization order) before its init functions are
+// run. The first call does
+// nothing unless the repackaged "flag" package was reset by another module.
+func Reset() {
+	if !used {{if .}}&& !flag.AmalgomatedWasReset() {{end}}{
+		used = true
+		return
+	}
+	used = true
+{{- if .}}
+	// the repackaged "flag" package is reset first so that flags can be defined again
+	flag.AmalgomatedReset()
+{{- end}}
+	for start := 0; start < len(registrations); {
+		end := start
+		for end < len(registrations) && registrations[end].pkg == registrations[start].pkg {
+			end++
+		}
+		var vars []Var
+		for _, r := range registrations[start:end] {
+			vars = append(vars, r.vars...)
+		}
+		sort.SliceStable(vars, func(i, j int) bool {
+			return vars[i].Rank < vars[j].Rank
+		})
+		for _, v := range vars {
+			v.Reset()
+		}
+		for _, r := range registrations[start:end] {
+			r.runInits()
+		}
+		start = end
+	}
+}
+`))
+
+const flagResetSrc = `// Code generated by amalgomate; DO NOT EDIT.
+
+package flag
+
+import "sort"
+
+type amalgomatedVar struct {
+	Rank  int
+	Reset func()
+}
+
+var (
+	amalgomatedVars     []amalgomatedVar
+	amalgomatedRunInits []func()
+	amalgomatedWasReset bool
+)
+
+func amalgomatedRegister(runInits func(), vars ...amalgomatedVar) {
+	amalgomatedRunInits = append(amalgomatedRunInits, runInits)
+	amalgomatedVars = append(amalgomatedVars, vars...)
+}
+
+// AmalgomatedWasReset returns true if AmalgomatedReset has been called, in which case the flags defined when the process
+// started are no longer defined.
+func AmalgomatedWasReset() bool {
+	return amalgomatedWasReset
+}
+
+// AmalgomatedReset restores the package-level variables of the package (including CommandLine) to the values of their
+// initializers in initialization order and runs its init functions again.
+func AmalgomatedReset() {
+	amalgomatedWasReset = true
+	sort.SliceStable(amalgomatedVars, func(i, j int) bool {
+		return amalgomatedVars[i].Rank < amalgomatedVars[j].Rank
+	})
+	for _, v := range amalgomatedVars {
+		v.Reset()
+	}
+	for _, f := range amalgomatedRunInits {
+		f()
+	}
+}
+`
+
+// addResetFunctions adds functions that restore the package-level state of the repackaged module in
+// repackagedModuleDir. importPath is the import path of repackagedModuleDir and flagImportPath is the import path of the
+// repackaged "flag" package (or blank if the "flag" package was not repackaged).
+//
+// A package named amalgomated_reset is written to repackagedModuleDir. An init function is appended to each of the
+// non-test Go files of the module that registers functions with that package: one that runs the init functions of the
+// file again and one for each package-level variable declared in the file that assigns the value of its initializer
+// (or the zero value if it has none) to the variable. The functions that restore variables are ranked by the position
+// of the variable in the initialization order of its package, which is determined by addPackageResetFunctions.
+// Variables that are declared with a compiler directive (such as go:embed) and blank variables are not assigned. If
+// rerunInits is true, the init functions of the file are renamed and called by both the appended init function and the
+// registered function; otherwise, the registered function does nothing and the package written by
+// rewriteInitFunctions (whose state is established by the init functions) is not modified.
+func addResetFunctions(repackagedModuleDir, importPath, flagImportPath string, rerunInits bool, skipDirs, buildTags []string) error {
+	resetDir := filepath.Join(repackagedModuleDir, resetPackage)
+	var src bytes.Buffer
+	if err := resetPackageTemplate.Execute(&src, flagImportPath); err != nil {
+		return errors.Wrapf(err, "failed to execute template")
+	}
+	if err := writeModulePackage(resetDir, src.Bytes()); err != nil {
+		return err
+	}
+	resetImportPath := path.Join(importPath, resetPackage)
+	// files of the module grouped by directory (and therefore by package)
+	var dirs []string
+	dirFiles := make(map[string][]string)
+	addFile := func(fpath string) error {
+		dir := filepath.Dir(fpath)
+		if _, ok := dirFiles[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		dirFiles[dir] = append(dirFiles[dir], fpath)
+		return nil
+	}
+	if err := rewriteModuleFiles(repackagedModuleDir, skipDirs, buildTags, addFile); err != nil {
+		return err
+	}
+	// the state of the packages written by amalgomate is also restored, except for the state of the package written by
+	// rewriteInitFunctions if the init functions are not run again
+	resetPkgs := []string{contextPackage, envPackage, stdioPackage, wdPackage}
+	if rerunInits {
+		resetPkgs = append(resetPkgs, lazyInitPackage)
+	}
+	for _, pkg := range resetPkgs {
+		fpath := filepath.Join(repackagedModuleDir, pkg, pkg+".go")
+		if _, err := os.Stat(fpath); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to stat %s", fpath)
+		}
+		if err := addFile(fpath); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range dirs {
+		relPath, err := filepath.Rel(repackagedModuleDir, dir)
+		if err != nil {
+			return errors.Wrapf(err, "failed to determine relative path")
+		}
+		pkgPath := path.Join(importPath, filepath.ToSlash(relPath))
+		registerFormat := resetPackage + ".Register(" + strconv.Quote(pkgPath) + ", %s)"
+		if err := addPackageResetFunctions(dirFiles[dir], registerFormat, resetPackage+".Var", rerunInits, resetPackage, resetImportPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFlagResetFunction adds an exported AmalgomatedReset function to the repackaged "flag" package in flagDir that
+// restores the package-level variables of the package and runs its init functions again. The init functions of the
+// package only configure CommandLine, so they are always run again.
+func addFlagResetFunction(flagDir string) error {
+	dirEntries, err := os.ReadDir(flagDir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list directory %s", flagDir)
+	}
+	var fpaths []string
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".go") || strings.HasSuffix(dirEntry.Name(), "_test.go") {
+			continue
+		}
+		fpaths = append(fpaths, filepath.Join(flagDir, dirEntry.Name()))
+	}
+	if err := addPackageResetFunctions(fpaths, "amalgomatedRegister(%s)", "amalgomatedVar", true, "", ""); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(flagDir, resetPackage+".go"), []byte(flagResetSrc), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write file in %s", flagDir)
+	}
+	return nil
+}
+
+// addPackageResetFunctions appends an init function to each of the provided files, which must be the files of a
+// single package in lexical order, that registers the functions that run the init functions of the file again and that
+// restore the package-level variables declared in the file. registerFormat is a format string that is given the
+// comma-separated arguments of the registration (the function literal that runs the init functions followed by a
+// composite literal of type varType with the fields Rank and Reset for each variable) and returns the expression that
+// registers them. If rerunInits is true, the init functions of the file are renamed and called by the appended init
+// function before the registration; otherwise, the init functions are not modified and the registered function does
+// nothing. If importName is non-empty, an import of importPath with that name is added to each modified file. The files
+// are rewritten as source text and then parsed and printed so that comments keep their positions.
+func addPackageResetFunctions(fpaths []string, registerFormat, varType string, rerunInits bool, importName, importPath string) error {
+	type parsedFile struct {
+		fpath    string
+		src      []byte
+		fileSet  *token.FileSet
+		fileNode *ast.File
+	}
+	var files []parsedFile
+	var fileNodes []*ast.File
+	for _, fpath := range fpaths {
+		src, err := os.ReadFile(fpath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read file %s", fpath)
+		}
+		fileSet := token.NewFileSet()
+		fileNode, err := parser.ParseFile(fileSet, fpath, src, parser.ParseComments)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse file %s", fpath)
+		}
+		files = append(files, parsedFile{fpath: fpath, src: src, fileSet: fileSet, fileNode: fileNode})
+		fileNodes = append(fileNodes, fileNode)
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+	vars := packageVars(fileNodes)
+	if err := rankPackageVars(fileNodes, vars); err != nil {
+		return errors.Wrapf(err, "failed to determine initialization order of package in %s", filepath.Dir(files[0].fpath))
+	}
+
+	for _, file := range files {
+		var varLits []string
+		for _, v := range vars {
+			if v.file != file.fileNode {
+				continue
+			}
+			stmts, err := v.resetStatements(file.fileSet)
+			if err != nil {
+				return errors.Wrapf(err, "failed to determine initializers of variables in file %s", file.fpath)
+			}
+			varLits = append(varLits, fmt.Sprintf("%s{Rank: %d, Reset: func() {\n%s\n}}", varType, v.rank, strings.Join(stmts, "\n")))
+		}
+
+		// the init functions are only renamed (and run again) if rerunInits is true
+		var initFuncs []*ast.FuncDecl
+		if rerunInits {
+			for _, decl := range file.fileNode.Decls {
+				if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil && funcDecl.Name.Name == "init" {
+					initFuncs = append(initFuncs, funcDecl)
+				}
+			}
+		}
+		if len(varLits) == 0 && len(initFuncs) == 0 {
+			continue
+		}
+
+		// rename the init functions from the end of the file so that the offsets of earlier functions remain valid
+		rewrittenSrc := slices.Clone(file.src)
+		tokenFile := file.fileSet.File(file.fileNode.Pos())
+		var initCalls []string
+		for i := len(initFuncs) - 1; i >= 0; i-- {
+			offset := tokenFile.Offset(initFuncs[i].Name.Pos())
+			rewrittenSrc = slices.Concat(rewrittenSrc[:offset], []byte(fmt.Sprintf("amalgomatedInit%d", i)), rewrittenSrc[offset+len("init"):])
+			initCalls = append([]string{fmt.Sprintf("amalgomatedInit%d()", i)}, initCalls...)
+		}
+
+		runInitsLit := "func() {}"
+		if len(initCalls) > 0 {
+			runInitsLit = "func() {\n" + strings.Join(initCalls, "\n") + "\n}"
+		}
+		registerExpr := fmt.Sprintf(registerFormat, strings.Join(append([]string{runInitsLit}, varLits...), ", "))
+		rewrittenSrc = append(rewrittenSrc, "\n\nfunc init() {\n"+strings.Join(append(initCalls, registerExpr), "\n")+"\n}\n"...)
+
+		fileSet := token.NewFileSet()
+		fileNode, err := parser.ParseFile(fileSet, file.fpath, rewrittenSrc, parser.ParseComments)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse file %s after adding reset functions", file.fpath)
+		}
+		if importName != "" && !astutil.AddNamedImport(fileSet, fileNode, importName, importPath) {
+			return errors.Errorf("failed to add import %s to file %s", importPath, file.fpath)
+		}
+		if err := writeAstToFile(file.fpath, fileNode, fileSet); err != nil {
+			return errors.Wrapf(err, "failed to write rewritten file %s", file.fpath)
+		}
+	}
+	return nil
+}
+
+// packageVar is a package-level variable that is restored by a reset function. Variables whose initializer is a
+// single multi-value expression are restored together, so a packageVar may have multiple names.
+type packageVar struct {
+	file *ast.File
+	// names are the names of the variables, which may include blank identifiers if value is a multi-value expression.
+	names []*ast.Ident
+	// value is the initializer of the variables, or nil if they have none.
+	value ast.Expr
+	// typ is the declared type of the variables, or nil if it is not declared.
+	typ ast.Expr
+	// rank is the position of the variables in the initialization order of the package.
+	rank int
+}
+
+// resetStatements returns the source of the statements that assign the value of the initializer of the variables to
+// them. Variables without an initializer are assigned their zero value using a function call so that values that
+// contain locks are not reported as copied.
+func (v *packageVar) resetStatements(fileSet *token.FileSet) ([]string, error) {
+	nodeSrc := func(node ast.Node) (string, error) {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fileSet, node); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	if v.value == nil {
+		typeSrc, err := nodeSrc(v.typ)
+		if err != nil {
+			return nil, err
+		}
+		return []string{fmt.Sprintf("%s = func() (zero %s) { return }()", v.names[0].Name, typeSrc)}, nil
+	}
+	valueSrc, err := nodeSrc(v.value)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range v.names {
+		names = append(names, name.Name)
+	}
+	return []string{strings.Join(names, ", ") + " = " + valueSrc}, nil
+}
+
+// packageVars returns the package-level variables declared in the provided files of a package, in declaration order.
+// Each variable with its own initializer (or without an initializer) is returned separately. Variables that are
+// declared with a compiler directive (such as go:embed or go:linkname) are skipped, as are blank variables.
+func packageVars(fileNodes []*ast.File) []*packageVar {
+	var vars []*packageVar
+	for _, fileNode := range fileNodes {
+		for _, decl := range fileNode.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				valueSpec := spec.(*ast.ValueSpec)
+				if hasDirective(valueSpec.Doc) || (!genDecl.Lparen.IsValid() && hasDirective(genDecl.Doc)) {
+					continue
+				}
+				if len(valueSpec.Values) == 1 && len(valueSpec.Names) > 1 {
+					if slices.ContainsFunc(valueSpec.Names, func(name *ast.Ident) bool { return name.Name != "_" }) {
+						vars = append(vars, &packageVar{file: fileNode, names: valueSpec.Names, value: valueSpec.Values[0]})
+					}
+					continue
+				}
+				for i, name := range valueSpec.Names {
+					if name.Name == "_" {
+						continue
+					}
+					v := &packageVar{file: fileNode, names: []*ast.Ident{name}, typ: valueSpec.Type}
+					if len(valueSpec.Values) > 0 {
+						v.value = valueSpec.Values[i]
+					}
+					vars = append(vars, v)
+				}
+			}
+		}
+	}
+	return vars
+}
+
+// rankPackageVars sets the rank of the provided variables of the package in the provided files to their position in
+// the initialization order of the package. As specified by the Go language, the next variable in the order is the
+// earliest variable in declaration order that does not depend on a variable that has not been initialized. The
+// dependencies of a variable are determined from its initializer without type information: a reference to a
+// package-level function depends on the references in its body, and a selector whose name is the name of a method
+// declared in the package depends on the references in the bodies of all of the methods with that name. Because method
+// references are matched by name, the dependencies may include variables that Go does not consider dependencies.
+// Returns an error if the variables depend on each other, which can only occur if such a dependency creates a cycle.
+func rankPackageVars(fileNodes []*ast.File, vars []*packageVar) error {
+	varsByName := make(map[string][]*packageVar)
+	for _, v := range vars {
+		for _, name := range v.names {
+			varsByName[name.Name] = append(varsByName[name.Name], v)
+		}
+	}
+	// bodies of package-level functions by name and of methods by "." followed by their name
+	funcBodies := make(map[string][]*ast.BlockStmt)
+	// declarations of package-level variables and functions, which identifiers that refer to them resolve to
+	topLevelDecls := make(map[any]bool)
+	for _, fileNode := range fileNodes {
+		for _, decl := range fileNode.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				topLevelDecls[decl] = true
+				if decl.Body == nil || (decl.Recv == nil && decl.Name.Name == "init") {
+					continue
+				}
+				key := decl.Name.Name
+				if decl.Recv != nil {
+					key = "." + key
+				}
+				funcBodies[key] = append(funcBodies[key], decl.Body)
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					topLevelDecls[spec] = true
+				}
+			}
+		}
+	}
+
+	// references returns the names of the package-level variables and functions and the keys of the methods that are
+	// referenced by the provided node
+	references := func(node ast.Node) []string {
+		var refs []string
+		var visit func(n ast.Node) bool
+		visit = func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				if _, ok := funcBodies["."+n.Sel.Name]; ok {
+					refs = append(refs, "."+n.Sel.Name)
+				}
+				ast.Inspect(n.X, visit)
+				return false
+			case *ast.Ident:
+				if n.Obj == nil || topLevelDecls[n.Obj.Decl] {
+					refs = append(refs, n.Name)
+				}
+			}
+			return true
+		}
+		ast.Inspect(node, visit)
+		return refs
+	}
+
+	deps := make(map[*packageVar]map[*packageVar]bool, len(vars))
+	for _, v := range vars {
+		deps[v] = make(map[*packageVar]bool)
+		if v.value == nil {
+			continue
+		}
+		visitedFuncs := make(map[string]bool)
+		pending := references(v.value)
+		for len(pending) > 0 {
+			ref := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			for _, dep := range varsByName[ref] {
+				if dep != v {
+					deps[v][dep] = true
+				}
+			}
+			if visitedFuncs[ref] {
+				continue
+			}
+			visitedFuncs[ref] = true
+			for _, body := range funcBodies[ref] {
+				pending = append(pending, references(body)...)
+			}
+		}
+	}
+
+	initialized := make(map[*packageVar]bool, len(vars))
+	for rank := range vars {
+		next := slices.IndexFunc(vars, func(v *packageVar) bool {
+			if initialized[v] {
+				return false
+			}
+			for dep := range deps[v] {
+				if !initialized[dep] {
+					return false
+				}
+			}
+			return true
+		})
+		if next == -1 {
+			var names []string
+			for _, v := range vars {
+				if !initialized[v] {
+					for _, name := range v.names {
+						names = append(names, name.Name)
+					}
+				}
+			}
+			return errors.Errorf("cannot determine the order in which the package-level variables %s are initialized", strings.Join(names, ", "))
+		}
+		vars[next].rank = rank
+		initialized[vars[next]] = true
+	}
+	return nil
+}
+
+// hasDirective returns true if the provided comment group contains a compiler directive such as "//go:embed".
+func hasDirective(cg *ast.CommentGroup) bool {
+	if cg == nil {
+		return false
+	}
+	for _, c := range cg.List {
+		if strings.HasPrefix(c.Text, "//go:") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_addPackageResetFunctions(t *testing.T) {
+	type file struct {
+		name string
+		src  string
+		want string
+	}
+	for _, tc := range []struct {
+		name       string
+		rerunInits bool
+		files      []file
+		wantErr    string
+	}{
+		{
+			name:       "resets variables and runs init functions",
+			rerunInits: true,
+			files: []file{{name: "foo.go", src: `package foo
+
+import (
+	"embed"
+	"strings"
+)
+
+var count int
+
+var (
+	// names are the names
+	names = []string{"a"}
+)
+
+var x, y = strings.Cut("a=b", "=")
+
+var _ = names
+
+var _, other = 1, 2
+
+//go:embed foo.txt
+var files embed.FS
+
+func init() {
+	// add a name
+	names = append(names, "b")
+}
+
+func init() {
+}
+`, want: `package foo
+
+import (
+	"embed"
+	"strings"
+	amalgomated_reset "github.com/project/internal/github.com/tool/amalgomated_reset"
+)
+
+var count int
+
+var (
+	// names are the names
+	names = []string{"a"}
+)
+
+var x, y = strings.Cut("a=b", "=")
+
+var _ = names
+
+var _, other = 1, 2
+
+//go:embed foo.txt
+var files embed.FS
+
+func amalgomatedInit0() {
+	// add a name
+	names = append(names, "b")
+}
+
+func amalgomatedInit1() {
+}
+
+func init() {
+	amalgomatedInit0()
+	amalgomatedInit1()
+	amalgomated_reset.Register("github.com/project/internal/github.com/tool/foo", func() {
+		amalgomatedInit0()
+		amalgomatedInit1()
+	}, amalgomated_reset.Var{Rank: 0, Reset: func() {
+		count = func() (zero int) { return }()
+	}}, amalgomated_reset.Var{Rank: 1, Reset: func() {
+		names = []string{"a"}
+	}}, amalgomated_reset.Var{Rank: 2, Reset: func() {
+		x, y = strings.Cut("a=b", "=")
+	}}, amalgomated_reset.Var{Rank: 3, Reset: func() {
+		other = 2
+	}})
+}
+`}},
+		},
+		{
+			name: "resets variables without running init functions",
+			files: []file{{name: "foo.go", src: `package foo
+
+var names = []string{"a"}
+
+func init() {
+	names = append(names, "b")
+}
+`, want: `package foo
+
+import amalgomated_reset "github.com/project/internal/github.com/tool/amalgomated_reset"
+
+var names = []string{"a"}
+
+func init() {
+	names = append(names, "b")
+}
+
+func init() {
+	amalgomated_reset.Register("github.com/project/internal/github.com/tool/foo", func() {}, amalgomated_reset.Var{Rank: 0, Reset: func() {
+		names = []string{"a"}
+	}})
+}
+`}},
+		},
+		{
+			name:       "ranks variables in initialization order across files",
+			rerunInits: true,
+			files: []file{{name: "a.go", src: `package foo
+
+var a = b + 1
+
+var c = f()
+
+func init() {
+	a++
+}
+`, want: `package foo
+
+import amalgomated_reset "github.com/project/internal/github.com/tool/amalgomated_reset"
+
+var a = b + 1
+
+var c = f()
+
+func amalgomatedInit0() {
+	a++
+}
+
+func init() {
+	amalgomatedInit0()
+	amalgomated_reset.Register("github.com/project/internal/github.com/tool/foo", func() {
+		amalgomatedInit0()
+	}, amalgomated_reset.Var{Rank: 1, Reset: func() {
+		a = b + 1
+	}}, amalgomated_reset.Var{Rank: 3, Reset: func() {
+		c = f()
+	}})
+}
+`}, {name: "b.go", src: `package foo
+
+var b = 2
+
+var d, e = 3, b
+
+func f() int {
+	return d
+}
+`, want: `package foo
+
+import amalgomated_reset "github.com/project/internal/github.com/tool/amalgomated_reset"
+
+var b = 2
+
+var d, e = 3, b
+
+func f() int {
+	return d
+}
+
+func init() {
+	amalgomated_reset.Register("github.com/project/internal/github.com/tool/foo", func() {}, amalgomated_reset.Var{Rank: 0, Reset: func() {
+		b = 2
+	}}, amalgomated_reset.Var{Rank: 2, Reset: func() {
+		d = 3
+	}}, amalgomated_reset.Var{Rank: 4, Reset: func() {
+		e = b
+	}})
+}
+`}},
+		},
+		{
+			name: "fails if the initialization order cannot be determined",
+			files: []file{{name: "foo.go", src: `package foo
+
+type named struct{}
+
+func (named) Name() string {
+	return "named"
+}
+
+type other struct{}
+
+func (other) Name() string {
+	return b
+}
+
+var a = named{}.Name()
+
+var b = a
+`}},
+			wantErr: "failed to determine initialization order of package in {{dir}}: cannot determine the order in which the package-level variables a, b are initialized",
+		},
+		{
+			name:       "does not modify files with only init functions if they are not run again",
+			rerunInits: false,
+			files: []file{{name: "foo.go", src: `package foo
+
+func init() {
+}
+`, want: `package foo
+
+func init() {
+}
+`}},
+		},
+		{
+			name: "does not modify files without variables or init functions",
+			files: []file{{name: "foo.go", src: `package foo
+
+const c = 1
+
+func F() {}
+`, want: `package foo
+
+const c = 1
+
+func F() {}
+`}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var fpaths []string
+			for _, f := range tc.files {
+				fpath := filepath.Join(dir, f.name)
+				require.NoError(t, os.WriteFile(fpath, []byte(f.src), 0644))
+				fpaths = append(fpaths, fpath)
+			}
+
+			err := addPackageResetFunctions(fpaths, `amalgomated_reset.Register("github.com/project/internal/github.com/tool/foo", %s)`, "amalgomated_reset.Var", tc.rerunInits, resetPackage, "github.com/project/internal/github.com/tool/amalgomated_reset")
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, strings.ReplaceAll(tc.wantErr, "{{dir}}", dir))
+				return
+			}
+			require.NoError(t, err)
+
+			for i, f := range tc.files {
+				got, err := os.ReadFile(fpaths[i])
+				require.NoError(t, err)
+				assert.Equal(t, f.want, string(got), "unexpected content of %s", f.name)
+			}
+		})
+	}
+}
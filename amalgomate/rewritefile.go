@@ -0,0 +1,218 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// writeModulePackage writes the provided source as the only file of the package written by amalgomate in pkgDir. The
+// name of the file is the name of the directory.
+func writeModulePackage(pkgDir string, src []byte) error {
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", pkgDir)
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, filepath.Base(pkgDir)+".go"), src, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write file in %s", pkgDir)
+	}
+	return nil
+}
+
+// generatedModulePackages are the names of the packages that may be written to the root of a repackaged module by
+// amalgomate. The packages do not access the standard streams, environment or working directory through the functions
+// that are rewritten, so they are not rewritten.
+var generatedModulePackages = []string{
+	contextPackage,
+	envPackage,
+	lazyInitPackage,
+	lockPackage,
+	resetPackage,
+	stdioPackage,
+	wdPackage,
+}
+
+// rewriteModuleFiles calls fn for each Go file of the repackaged module in repackagedModuleDir that is rewritten by
+// amalgomate. Test files, directories in skipDirs (which contain other repackaged modules), "testdata" directories, the
+// directories of the packages in generatedModulePackages and files that are excluded from every build by their build
+// constraints (given the provided build tags) are skipped.
+func rewriteModuleFiles(repackagedModuleDir string, skipDirs, buildTags []string, fn func(fpath string) error) error {
+	return filepath.WalkDir(repackagedModuleDir, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if fpath != repackagedModuleDir && (d.Name() == "testdata" || slices.Contains(skipDirs, fpath) ||
+				(filepath.Dir(fpath) == repackagedModuleDir && slices.Contains(generatedModulePackages, d.Name()))) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".go") || strings.HasSuffix(d.Name(), "_test.go") {
+			return nil
+		}
+		fileNode, err := parser.ParseFile(token.NewFileSet(), fpath, nil, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse file %s", fpath)
+		}
+		if excludedByBuildConstraints(fileNode, buildTags) {
+			return nil
+		}
+		return fn(fpath)
+	})
+}
+
+// rewriteFile parses the provided file and calls rewrite, which modifies the parsed file and returns true if it was
+// modified. If the file was modified, the imports of the packages whose functions are replaced by the rewrites are
+// removed if they are no longer used, an import of importPath with the name importName is added if importName is
+// non-empty and the file is written.
+func rewriteFile(fpath, importName, importPath string, rewrite func(fileNode *ast.File) bool) error {
+	fileSet := token.NewFileSet()
+	fileNode, err := parser.ParseFile(fileSet, fpath, nil, parser.ParseComments)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse file %s", fpath)
+	}
+	if !rewrite(fileNode) {
+		return nil
+	}
+	for _, rewrittenImportPath := range []string{"context", "log", "os", "os/signal", "path/filepath"} {
+		if packageImportName(fileNode, rewrittenImportPath) != "" && !astutil.UsesImport(fileNode, rewrittenImportPath) {
+			astutil.DeleteNamedImport(fileSet, fileNode, namedImportName(fileNode, rewrittenImportPath), rewrittenImportPath)
+		}
+	}
+	if importName != "" && !astutil.AddNamedImport(fileSet, fileNode, importName, importPath) {
+		return errors.Errorf("failed to add import %s to file %s", importPath, fpath)
+	}
+	if err := writeAstToFile(fpath, fileNode, fileSet); err != nil {
+		return errors.Wrapf(err, "failed to write rewritten file %s", fpath)
+	}
+	return nil
+}
+
+// replacePackageFuncs replaces the references to functions in the provided file with references to the functions with
+// the same names in the package imported with the name replacementPkg. funcs maps the import paths of packages to the
+// names of the functions of the package that are replaced. Returns true if any references were replaced.
+func replacePackageFuncs(fileNode *ast.File, funcs map[string][]string, replacementPkg string) bool {
+	funcsForPkgName := make(map[string][]string)
+	for importPath, names := range funcs {
+		if pkgName := packageImportName(fileNode, importPath); pkgName != "" {
+			funcsForPkgName[pkgName] = names
+		}
+	}
+	if len(funcsForPkgName) == 0 {
+		return false
+	}
+	updated := false
+	astutil.Apply(fileNode, func(c *astutil.Cursor) bool {
+		sel, ok := c.Node().(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		for pkgName, names := range funcsForPkgName {
+			if isPackageSelector(sel, pkgName) && slices.Contains(names, sel.Sel.Name) {
+				c.Replace(&ast.SelectorExpr{X: ast.NewIdent(replacementPkg), Sel: ast.NewIdent(sel.Sel.Name)})
+				updated = true
+			}
+		}
+		return true
+	}, nil)
+	return updated
+}
+
+// execFuncs maps the import paths of the packages whose functions create the commands that start child processes to
+// the names of those functions.
+var execFuncs = map[string][]string{
+	"os/exec": {
+		"Command",
+		"CommandContext",
+	},
+}
+
+// wrapPackageCalls replaces the calls to functions in the provided file with calls to the function named wrapperFunc in
+// the package imported with the name wrapperPkg whose only argument is the original call. funcs maps the import paths
+// of packages to the names of the functions of the package whose calls are wrapped. References to the functions that
+// are not calls (such as function values) are not modified. Returns true if any calls were wrapped.
+func wrapPackageCalls(fileNode *ast.File, funcs map[string][]string, wrapperPkg, wrapperFunc string) bool {
+	funcsForPkgName := make(map[string][]string)
+	for importPath, names := range funcs {
+		if pkgName := packageImportName(fileNode, importPath); pkgName != "" {
+			funcsForPkgName[pkgName] = names
+		}
+	}
+	if len(funcsForPkgName) == 0 {
+		return false
+	}
+	updated := false
+	// calls are replaced after their children are visited so that the replacement is not visited
+	astutil.Apply(fileNode, nil, func(c *astutil.Cursor) bool {
+		call, ok := c.Node().(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		for pkgName, names := range funcsForPkgName {
+			if isPackageSelector(sel, pkgName) && slices.Contains(names, sel.Sel.Name) {
+				c.Replace(&ast.CallExpr{
+					Fun:  &ast.SelectorExpr{X: ast.NewIdent(wrapperPkg), Sel: ast.NewIdent(wrapperFunc)},
+					Args: []ast.Expr{call},
+				})
+				updated = true
+			}
+		}
+		return true
+	})
+	return updated
+}
+
+// packageImportName returns the name by which the provided file refers to the package with the provided import path, which
+// must be a standard library package whose name is the last element of its import path. Returns an empty string if the
+// package is not imported or is only imported using a blank or dot import.
+func packageImportName(fileNode *ast.File, importPath string) string {
+	for _, currImport := range fileNode.Imports {
+		if currImportPath, err := strconv.Unquote(currImport.Path.Value); err != nil || currImportPath != importPath {
+			continue
+		}
+		if currImport.Name == nil {
+			return path.Base(importPath)
+		}
+		if currImport.Name.Name != "_" && currImport.Name.Name != "." {
+			return currImport.Name.Name
+		}
+	}
+	return ""
+}
+
+// namedImportName returns the explicit name of the import of the provided import path in the provided file, or an
+// empty string if the import is not named.
+func namedImportName(fileNode *ast.File, importPath string) string {
+	for _, currImport := range fileNode.Imports {
+		if currImportPath, err := strconv.Unquote(currImport.Path.Value); err == nil && currImportPath == importPath && currImport.Name != nil {
+			return currImport.Name.Name
+		}
+	}
+	return ""
+}
+
+// isPackageSelector returns true if the provided selector expression selects a member of the package imported with the
+// provided name. Identifiers that are resolved to a declaration in the file (such as a local variable that shadows the
+// import) do not refer to the package.
+func isPackageSelector(sel *ast.SelectorExpr, pkgName string) bool {
+	ident, ok := sel.X.(*ast.Ident)
+	return ok && pkgName != "" && ident.Name == pkgName && ident.Obj == nil
+}
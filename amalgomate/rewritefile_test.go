@@ -0,0 +1,88 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rewriteModuleFiles(t *testing.T) {
+	files := map[string]string{
+		"foo.go":                       "package foo\n",
+		"foo_test.go":                  "package foo\n",
+		"ignored.go":                   "//go:build ignore\n\npackage foo\n",
+		"tools.go":                     "//go:build tools\n\npackage foo\n",
+		"README.md":                    "# foo\n",
+		"bar/bar.go":                   "package bar\n",
+		"bar/amalgomated_init/init.go": "package amalgomated_init\n",
+		"testdata/data.go":             "package data\n",
+		"other/other.go":               "package other\n",
+		"amalgomated_init/init.go":     "package amalgomated_init\n",
+	}
+	for _, tc := range []struct {
+		name      string
+		buildTags []string
+		want      []string
+	}{
+		{
+			name: "walks rewritten files",
+			want: []string{"bar/amalgomated_init/init.go", "bar/bar.go", "foo.go"},
+		},
+		{
+			name:      "walks files included by build tags",
+			buildTags: []string{"tools"},
+			want:      []string{"bar/amalgomated_init/init.go", "bar/bar.go", "foo.go", "tools.go"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, src := range files {
+				fpath := filepath.Join(dir, name)
+				require.NoError(t, os.MkdirAll(filepath.Dir(fpath), 0755))
+				require.NoError(t, os.WriteFile(fpath, []byte(src), 0644))
+			}
+
+			var got []string
+			err := rewriteModuleFiles(dir, []string{filepath.Join(dir, "other")}, tc.buildTags, func(fpath string) error {
+				relPath, err := filepath.Rel(dir, fpath)
+				if err != nil {
+					return err
+				}
+				got = append(got, filepath.ToSlash(relPath))
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+type rewriteFileTestCase struct {
+	name string
+	src  string
+	want string
+}
+
+// runRewriteFileTests runs a test for each of the provided test cases that writes the source of the test case to a
+// file, calls rewrite with the path of the file and verifies that the file has the wanted content.
+func runRewriteFileTests(t *testing.T, tcs []rewriteFileTestCase, rewrite func(fpath string) error) {
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			fpath := filepath.Join(t.TempDir(), "foo.go")
+			require.NoError(t, os.WriteFile(fpath, []byte(tc.src), 0644))
+
+			require.NoError(t, rewrite(fpath))
+
+			got, err := os.ReadFile(fpath)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+//go:generate go run schema_generate.go
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+// ConfigJSONSchema returns the JSON Schema that describes the configuration file format. The schema is derived from
+// the Config type and can be used by editors to validate configuration files.
+func ConfigJSONSchema() ([]byte, error) {
+	defs := make(map[string]*jsonSchema)
+	schema, err := jsonSchemaForType(reflect.TypeOf(Config{}), defs, true)
+	if err != nil {
+		return nil, err
+	}
+	schema.Schema = "https://json-schema.org/draft/2020-12/schema"
+	schema.Title = "amalgomate configuration"
+	schema.Defs = defs
+
+	schemaBytes, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal JSON schema")
+	}
+	return append(schemaBytes, '\n'), nil
+}
+
+// jsonSchemaForType returns the schema for the provided type. If root is false, the schemas for struct types are added
+// to defs and a reference to the definition is returned.
+func jsonSchemaForType(t reflect.Type, defs map[string]*jsonSchema, root bool) (*jsonSchema, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return &jsonSchema{Type: "string"}, nil
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &jsonSchema{Type: "integer"}, nil
+	case reflect.Slice:
+		itemSchema, err := jsonSchemaForType(t.Elem(), defs, false)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "array", Items: itemSchema}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, errors.Errorf("map type %v must have string keys", t)
+		}
+		valueSchema, err := jsonSchemaForType(t.Elem(), defs, false)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "object", AdditionalProperties: valueSchema}, nil
+	case reflect.Struct:
+		if !root {
+			if _, ok := defs[t.Name()]; !ok {
+				// add placeholder before computing the definition to support recursive types
+				defs[t.Name()] = nil
+				structSchema, err := jsonSchemaForType(t, defs, true)
+				if err != nil {
+					return nil, err
+				}
+				defs[t.Name()] = structSchema
+			}
+			return &jsonSchema{Ref: "#/$defs/" + t.Name()}, nil
+		}
+		properties := make(map[string]*jsonSchema)
+		for i := 0; i < t.NumField(); i++ {
+			name := configFieldName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			fieldSchema, err := jsonSchemaForType(t.Field(i).Type, defs, false)
+			if err != nil {
+				return nil, err
+			}
+			properties[name] = fieldSchema
+		}
+		return &jsonSchema{Type: "object", Properties: properties, AdditionalProperties: false}, nil
+	default:
+		return nil, errors.Errorf("unsupported type %v", t)
+	}
+}
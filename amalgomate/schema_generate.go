@@ -0,0 +1,27 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+//go:build ignore
+
+// Writes the JSON Schema for the configuration to the root of the repository. Run using "go generate".
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/palantir/amalgomate/amalgomate"
+)
+
+func main() {
+	schema, err := amalgomate.ConfigJSONSchema()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile("../config.schema.json", schema, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"bytes"
+	"go/ast"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// stdioPackage is the name of the package (and of its directory in the repackaged module) that provides the standard
+// streams used by the code of a repackaged module.
+const stdioPackage = "amalgomated_stdio"
+
+var stdioPackageTemplate = template.Must(template.New("stdio").Parse(`// Code generated by amalgomate; DO NOT EDIT.
+
+// Package amalgomated_stdio provides the standard streams that are used by the code of a repackaged module in place of
+// os.Stdin, os.Stdout and os.Stderr and the logger that is used in place of the standard logger of the "log" package so
+// that they can be redirected when its program is run.
+package amalgomated_stdio
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+{{- if .}}
+
+	flag "{{.}}"
+{{- end}}
+)
+
+var Stdin, Stdout, Stderr = os.Stdin, os.Stdout, os.Stderr
+
+// Logger is used by the code of the module in place of the standard logger of the "log" package, which is shared by
+// the whole process.
+var Logger = log.New(Stderr, "", log.LstdFlags)
+
+// Redirect sets the standard streams of the module to the provided reader and writers and returns a function that
+// restores the previous streams. Streams that are nil are not changed. Readers and writers that are not files are
+// connected to the streams using pipes, and the returned function waits until the output written to the pipes has been
+// copied to the writers. The input of a reader that is not a file is copied by a goroutine that exits once a read from
+// the reader returns after the function is called, so a reader that blocks indefinitely keeps the goroutine running.
+// The output of Logger is redirected to stderr.
+func Redirect(stdin io.Reader, stdout, stderr io.Writer) (restore func()) {
+	prevStdin, prevStdout, prevStderr, prevLogOutput := Stdin, Stdout, Stderr, Logger.Writer()
+	var closers []func()
+	if stdin != nil {
+		var closeStdin func()
+		Stdin, closeStdin = inputFile(stdin)
+		closers = append(closers, closeStdin)
+	}
+	if stdout != nil {
+		var closeStdout func()
+		Stdout, closeStdout = outputFile(stdout)
+		closers = append(closers, closeStdout)
+	}
+	if stderr != nil {
+		if sameWriter(stderr, stdout) {
+			// share the stream so that writes to the writer are not concurrent and keep their order
+			Stderr = Stdout
+		} else {
+			var closeStderr func()
+			Stderr, closeStderr = outputFile(stderr)
+			closers = append(closers, closeStderr)
+		}
+		Logger.SetOutput(Stderr)
+	}
+{{- if .}}
+	flag.AmalgomatedStdin, flag.AmalgomatedStdout, flag.AmalgomatedStderr = Stdin, Stdout, Stderr
+{{- end}}
+	return func() {
+		for _, closeStream := range closers {
+			closeStream()
+		}
+		Stdin, Stdout, Stderr = prevStdin, prevStdout, prevStderr
+		Logger.SetOutput(prevLogOutput)
+{{- if .}}
+		flag.AmalgomatedStdin, flag.AmalgomatedStdout, flag.AmalgomatedStderr = Stdin, Stdout, Stderr
+{{- end}}
+	}
+}
+
+// The following functions are used by the code of the module in place of the functions of the "log" package that use
+// the standard logger.
+
+func Default() *log.Logger { return Logger }
+
+func Flags() int { return Logger.Flags() }
+
+func Prefix() string { return Logger.Prefix() }
+
+func Writer() io.Writer { return Logger.Writer() }
+
+func SetFlags(flag int) { Logger.SetFlags(flag) }
+
+func SetPrefix(prefix string) { Logger.SetPrefix(prefix) }
+
+func SetOutput(w io.Writer) { Logger.SetOutput(w) }
+
+func Output(calldepth int, s string) error { return Logger.Output(calldepth+1, s) }
+
+func Print(v ...any) { _ = Logger.Output(2, fmt.Sprint(v...)) }
+
+func Printf(format string, v ...any) { _ = Logger.Output(2, fmt.Sprintf(format, v...)) }
+
+func Println(v ...any) { _ = Logger.Output(2, fmt.Sprintln(v...)) }
+
+func Fatal(v ...any) {
+	_ = Logger.Output(2, fmt.Sprint(v...))
+	os.Exit(1)
+}
+
+func Fatalf(format string, v ...any) {
+	_ = Logger.Output(2, fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+func Fatalln(v ...any) {
+	_ = Logger.Output(2, fmt.Sprintln(v...))
+	os.Exit(1)
+}
+
+func Panic(v ...any) {
+	s := fmt.Sprint(v...)
+	_ = Logger.Output(2, s)
+	panic(s)
+}
+
+func Panicf(format string, v ...any) {
+	s := fmt.Sprintf(format, v...)
+	_ = Logger.Output(2, s)
+	panic(s)
+}
+
+func Panicln(v ...any) {
+	s := fmt.Sprintln(v...)
+	_ = Logger.Output(2, s)
+	panic(s)
+}
+
+// sameWriter returns true if the provided writers are equal. Writers whose dynamic values are not comparable (which
+// would make the comparison panic) are not equal.
+func sameWriter(w1, w2 io.Writer) bool {
+	return reflect.TypeOf(w1) == reflect.TypeOf(w2) && reflect.ValueOf(w1).Comparable() && w1 == w2
+}
+
+func inputFile(r io.Reader) (f *os.File, closeFile func()) {
+	if f, ok := r.(*os.File); ok {
+		return f, func() {}
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		_, _ = io.Copy(pw, r)
+		_ = pw.Close()
+	}()
+	return pr, func() {
+		_ = pr.Close()
+	}
+}
+
+func outputFile(w io.Writer) (f *os.File, closeFile func()) {
+	if f, ok := w.(*os.File); ok {
+		return f, func() {}
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(w, pr)
+		_ = pr.Close()
+		close(done)
+	}()
+	return pw, func() {
+		_ = pw.Close()
+		<-done
+	}
+}
+`))
+
+const flagStdioSrc = `// Code generated by amalgomate; DO NOT EDIT.
+
+package flag
+
+import "os"
+
+// AmalgomatedStdin, AmalgomatedStdout and AmalgomatedStderr are used by the package in place of os.Stdin, os.Stdout and
+// os.Stderr so that they can be redirected when a program is run.
+var AmalgomatedStdin, AmalgomatedStdout, AmalgomatedStderr = os.Stdin, os.Stdout, os.Stderr
+`
+
+// stdioFmtFuncs maps the functions of the "fmt" package that use the standard streams to the functions that take the
+// stream as their first argument and the name of the stream.
+var stdioFmtFuncs = map[string]struct {
+	Func   string
+	Stream string
+}{
+	"Print":   {Func: "Fprint", Stream: "Stdout"},
+	"Printf":  {Func: "Fprintf", Stream: "Stdout"},
+	"Println": {Func: "Fprintln", Stream: "Stdout"},
+	"Scan":    {Func: "Fscan", Stream: "Stdin"},
+	"Scanf":   {Func: "Fscanf", Stream: "Stdin"},
+	"Scanln":  {Func: "Fscanln", Stream: "Stdin"},
+}
+
+// stdioLogFuncs are the functions of the "log" package that use the standard logger. They are replaced with the
+// functions of the same name in the amalgomated_stdio package, which use a logger that is local to the module.
+var stdioLogFuncs = []string{
+	"Default", "Fatal", "Fatalf", "Fatalln", "Flags", "Output", "Panic", "Panicf", "Panicln", "Prefix", "Print", "Printf",
+	"Println", "SetFlags", "SetOutput", "SetPrefix", "Writer",
+}
+
+// redirectStdio rewrites the repackaged module in repackagedModuleDir so that its standard streams can be redirected.
+// importPath is the import path of repackagedModuleDir and flagImportPath is the import path of the repackaged "flag"
+// package (or blank if the "flag" package was not repackaged).
+//
+// A package named amalgomated_stdio is written to repackagedModuleDir. In the non-test Go files of the module,
+// references to os.Stdin, os.Stdout and os.Stderr are replaced with references to the variables of that package and
+// calls to the functions of the "fmt" package that use the standard streams (such as fmt.Println) are replaced with
+// calls to the corresponding functions that take the stream as an argument (such as fmt.Fprintln). References to the
+// functions of the "log" package that use the standard logger (such as log.Printf) are replaced with references to the
+// functions of that package, which use a logger that is local to the module, so that redirecting the streams of the
+// module does not change the output of the standard logger of the process.
+func redirectStdio(repackagedModuleDir, importPath, flagImportPath string, skipDirs, buildTags []string) error {
+	stdioDir := filepath.Join(repackagedModuleDir, stdioPackage)
+	var src bytes.Buffer
+	if err := stdioPackageTemplate.Execute(&src, flagImportPath); err != nil {
+		return errors.Wrapf(err, "failed to execute template")
+	}
+	if err := writeModulePackage(stdioDir, src.Bytes()); err != nil {
+		return err
+	}
+	stdioImportPath := path.Join(importPath, stdioPackage)
+
+	return rewriteModuleFiles(repackagedModuleDir, skipDirs, buildTags, func(fpath string) error {
+		return redirectStdioInFile(fpath, func(stream string) ast.Expr {
+			return &ast.SelectorExpr{X: ast.NewIdent(stdioPackage), Sel: ast.NewIdent(stream)}
+		}, stdioPackage, stdioImportPath)
+	})
+}
+
+// redirectFlagStdio rewrites the repackaged "flag" package in flagDir so that its standard streams can be redirected.
+// References to os.Stdin, os.Stdout and os.Stderr are replaced with references to the exported AmalgomatedStdin,
+// AmalgomatedStdout and AmalgomatedStderr variables of the package, which are set by the amalgomated_stdio packages
+// of the repackaged modules.
+func redirectFlagStdio(flagDir string) error {
+	dirEntries, err := os.ReadDir(flagDir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list directory %s", flagDir)
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".go") || strings.HasSuffix(dirEntry.Name(), "_test.go") {
+			continue
+		}
+		if err := redirectStdioInFile(filepath.Join(flagDir, dirEntry.Name()), func(stream string) ast.Expr {
+			return ast.NewIdent("Amalgomated" + stream)
+		}, "", ""); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(flagDir, stdioPackage+".go"), []byte(flagStdioSrc), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write file in %s", flagDir)
+	}
+	return nil
+}
+
+// redirectStdioInFile performs the rewrite described in redirectStdio for the provided file. streamExpr returns the
+// expression that refers to the stream with the provided name ("Stdin", "Stdout" or "Stderr"). If the file is
+// rewritten and importName is non-empty, an import of importPath with that name is added to the file. If importName is
+// non-empty, the references to the functions of the "log" package that use the standard logger are also replaced.
+func redirectStdioInFile(fpath string, streamExpr func(stream string) ast.Expr, importName, importPath string) error {
+	return rewriteFile(fpath, importName, importPath, func(fileNode *ast.File) bool {
+		osName, fmtName := packageImportName(fileNode, "os"), packageImportName(fileNode, "fmt")
+		updated := false
+		if importName != "" {
+			updated = replacePackageFuncs(fileNode, map[string][]string{"log": stdioLogFuncs}, importName)
+		}
+		if osName == "" && fmtName == "" {
+			return updated
+		}
+		astutil.Apply(fileNode, func(c *astutil.Cursor) bool {
+			switch node := c.Node().(type) {
+			case *ast.SelectorExpr:
+				if isPackageSelector(node, osName) && (node.Sel.Name == "Stdin" || node.Sel.Name == "Stdout" || node.Sel.Name == "Stderr") {
+					c.Replace(streamExpr(node.Sel.Name))
+					updated = true
+				}
+			case *ast.CallExpr:
+				sel, ok := node.Fun.(*ast.SelectorExpr)
+				if !ok || !isPackageSelector(sel, fmtName) {
+					break
+				}
+				if fmtFunc, ok := stdioFmtFuncs[sel.Sel.Name]; ok {
+					sel.Sel = ast.NewIdent(fmtFunc.Func)
+					node.Args = append([]ast.Expr{streamExpr(fmtFunc.Stream)}, node.Args...)
+					updated = true
+				}
+			}
+			return true
+		}, nil)
+		return updated
+	})
+}
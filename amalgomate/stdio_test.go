@@ -0,0 +1,152 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/ast"
+	"testing"
+)
+
+func Test_redirectStdioInFile(t *testing.T) {
+	runRewriteFileTests(t, []rewriteFileTestCase{
+		{
+			name: "rewrites standard streams and fmt functions",
+			src: `package foo
+
+import (
+	"fmt"
+	"os"
+)
+
+func F() {
+	fmt.Println("out")
+	fmt.Fprintln(os.Stderr, "err")
+	var s string
+	fmt.Scan(&s)
+	os.Exit(1)
+}
+`,
+			want: `package foo
+
+import (
+	"fmt"
+	"os"
+	amalgomated_stdio "github.com/project/internal/github.com/tool/amalgomated_stdio"
+)
+
+func F() {
+	fmt.Fprintln(amalgomated_stdio.Stdout, "out")
+	fmt.Fprintln(amalgomated_stdio.Stderr, "err")
+	var s string
+	fmt.Fscan(amalgomated_stdio.Stdin, &s)
+	os.Exit(1)
+}
+`,
+		},
+		{
+			name: "removes unused os import",
+			src: `package foo
+
+import (
+	"io"
+	goos "os"
+)
+
+var w io.Writer = goos.Stdout
+`,
+			want: `package foo
+
+import (
+	"io"
+	amalgomated_stdio "github.com/project/internal/github.com/tool/amalgomated_stdio"
+)
+
+var w io.Writer = amalgomated_stdio.Stdout
+`,
+		},
+		{
+			name: "rewrites functions that use the standard logger",
+			src: `package foo
+
+import "log"
+
+var printf = log.Printf
+
+func F() *log.Logger {
+	log.SetFlags(0)
+	log.Println("out")
+	return log.New(log.Writer(), "", 0)
+}
+`,
+			want: `package foo
+
+import (
+	"log"
+	amalgomated_stdio "github.com/project/internal/github.com/tool/amalgomated_stdio"
+)
+
+var printf = amalgomated_stdio.Printf
+
+func F() *log.Logger {
+	amalgomated_stdio.SetFlags(0)
+	amalgomated_stdio.Println("out")
+	return log.New(amalgomated_stdio.Writer(), "", 0)
+}
+`,
+		},
+		{
+			name: "removes unused log import",
+			src: `package foo
+
+import "log"
+
+func F() {
+	log.Fatal("failed")
+}
+`,
+			want: `package foo
+
+import amalgomated_stdio "github.com/project/internal/github.com/tool/amalgomated_stdio"
+
+func F() {
+	amalgomated_stdio.Fatal("failed")
+}
+`,
+		},
+		{
+			name: "does not rewrite identifiers that shadow imports",
+			src: `package foo
+
+import "fmt"
+
+type printer struct{}
+
+func (printer) Println(...any) {}
+
+func F() {
+	fmt := printer{}
+	fmt.Println("out")
+}
+`,
+			want: `package foo
+
+import "fmt"
+
+type printer struct{}
+
+func (printer) Println(...any) {}
+
+func F() {
+	fmt := printer{}
+	fmt.Println("out")
+}
+`,
+		},
+	}, func(fpath string) error {
+		return redirectStdioInFile(fpath, func(stream string) ast.Expr {
+			return &ast.SelectorExpr{X: ast.NewIdent(stdioPackage), Sel: ast.NewIdent(stream)}
+		}, stdioPackage, "github.com/project/internal/github.com/tool/amalgomated_stdio")
+	})
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import (
+	"go/ast"
+	"path"
+	"path/filepath"
+)
+
+// wdPackage is the name of the package (and of its directory in the repackaged module) that provides the working
+// directory used by the code of a repackaged module.
+const wdPackage = "amalgomated_wd"
+
+const wdPackageSrc = `// Code generated by amalgomate; DO NOT EDIT.
+
+// Package amalgomated_wd provides the working directory that is used by the code of a repackaged module in place of the
+// working directory of the process so that it can be set when its program is run. The functions of the package resolve
+// relative paths against the working directory of the module and otherwise behave like the functions of the "os" and
+// "path/filepath" packages with the same names.
+package amalgomated_wd
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	mu sync.RWMutex
+	// wd is the absolute working directory of the module, or blank if the working directory of the process is used.
+	wd string
+)
+
+// Set sets the working directory of the module to the provided directory and returns a function that restores the
+// previous working directory. If dir is blank, the working directory of the process is used. If dir is relative, it is
+// resolved against the working directory of the process.
+func Set(dir string) (restore func()) {
+	if dir != "" {
+		if absDir, err := filepath.Abs(dir); err == nil {
+			dir = absDir
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	prevWd := wd
+	wd = dir
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		wd = prevWd
+	}
+}
+
+// Cmd sets the directory of the provided command to the working directory of the module if the working directory of
+// the module is set and the directory of the command is not, and returns the command. Calls to exec.Command and
+// exec.CommandContext are wrapped with calls to Cmd so that child processes are started in the working directory of
+// the module.
+func Cmd(cmd *exec.Cmd) *exec.Cmd {
+	mu.RLock()
+	defer mu.RUnlock()
+	if wd != "" && cmd.Dir == "" {
+		cmd.Dir = wd
+	}
+	return cmd
+}
+
+// resolve returns the path that the provided name refers to: relative names are joined with the working directory of
+// the module if it is set.
+func resolve(name string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if wd == "" || name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(wd, name)
+}
+
+// restorePath replaces the path in the provided error with the provided name so that errors refer to the names that
+// were provided by the caller.
+func restorePath(err error, name string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		pathErr.Path = name
+	}
+	return err
+}
+
+func Getwd() (string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if wd == "" {
+		return os.Getwd()
+	}
+	return wd, nil
+}
+
+func Chdir(dir string) error {
+	mu.RLock()
+	virtual := wd != ""
+	mu.RUnlock()
+	if !virtual {
+		return os.Chdir(dir)
+	}
+	resolved := resolve(dir)
+	fi, err := os.Stat(resolved)
+	if err != nil {
+		return &fs.PathError{Op: "chdir", Path: dir, Err: errors.Unwrap(err)}
+	}
+	if !fi.IsDir() {
+		return &fs.PathError{Op: "chdir", Path: dir, Err: errors.New("not a directory")}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	wd = resolved
+	return nil
+}
+
+func Abs(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if wd == "" {
+		return filepath.Abs(path)
+	}
+	return filepath.Join(wd, path), nil
+}
+
+func Open(name string) (*os.File, error) {
+	f, err := os.Open(resolve(name))
+	return f, restorePath(err, name)
+}
+
+func OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(resolve(name), flag, perm)
+	return f, restorePath(err, name)
+}
+
+func Create(name string) (*os.File, error) {
+	f, err := os.Create(resolve(name))
+	return f, restorePath(err, name)
+}
+
+func ReadFile(name string) ([]byte, error) {
+	b, err := os.ReadFile(resolve(name))
+	return b, restorePath(err, name)
+}
+
+func WriteFile(name string, data []byte, perm os.FileMode) error {
+	return restorePath(os.WriteFile(resolve(name), data, perm), name)
+}
+
+func ReadDir(name string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(resolve(name))
+	return entries, restorePath(err, name)
+}
+
+func Stat(name string) (os.FileInfo, error) {
+	fi, err := os.Stat(resolve(name))
+	return fi, restorePath(err, name)
+}
+
+func Lstat(name string) (os.FileInfo, error) {
+	fi, err := os.Lstat(resolve(name))
+	return fi, restorePath(err, name)
+}
+
+func Mkdir(name string, perm os.FileMode) error {
+	return restorePath(os.Mkdir(resolve(name), perm), name)
+}
+
+func MkdirAll(path string, perm os.FileMode) error {
+	return restorePath(os.MkdirAll(resolve(path), perm), path)
+}
+
+func Remove(name string) error {
+	return restorePath(os.Remove(resolve(name)), name)
+}
+
+func RemoveAll(path string) error {
+	return restorePath(os.RemoveAll(resolve(path)), path)
+}
+
+func Rename(oldpath, newpath string) error {
+	err := os.Rename(resolve(oldpath), resolve(newpath))
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		linkErr.Old, linkErr.New = oldpath, newpath
+	}
+	return err
+}
+`
+
+// wdFuncs maps the import paths of the packages whose functions use the working directory of the process to the names
+// of those functions. The package written by redirectWd declares a function with the same name and signature for each
+// of them.
+var wdFuncs = map[string][]string{
+	"os": {
+		"Chdir",
+		"Create",
+		"Getwd",
+		"Lstat",
+		"Mkdir",
+		"MkdirAll",
+		"Open",
+		"OpenFile",
+		"ReadDir",
+		"ReadFile",
+		"Remove",
+		"RemoveAll",
+		"Rename",
+		"Stat",
+		"WriteFile",
+	},
+	"path/filepath": {
+		"Abs",
+	},
+}
+
+// redirectWd rewrites the repackaged module in repackagedModuleDir so that its working directory can be set
+// independently of the working directory of the process. importPath is the import path of repackagedModuleDir.
+//
+// A package named amalgomated_wd is written to repackagedModuleDir. In the non-test Go files of the module, references
+// to the functions of the "os" and "path/filepath" packages that use the working directory (such as os.Getwd, os.Open
+// and filepath.Abs) are replaced with references to the corresponding functions of that package, and calls to
+// exec.Command and exec.CommandContext are wrapped with calls to its Cmd function so that the commands that do not set
+// their directory are started in the working directory of the module.
+func redirectWd(repackagedModuleDir, importPath string, skipDirs, buildTags []string) error {
+	wdDir := filepath.Join(repackagedModuleDir, wdPackage)
+	if err := writeModulePackage(wdDir, []byte(wdPackageSrc)); err != nil {
+		return err
+	}
+	wdImportPath := path.Join(importPath, wdPackage)
+
+	return rewriteModuleFiles(repackagedModuleDir, skipDirs, buildTags, func(fpath string) error {
+		return redirectWdInFile(fpath, wdImportPath)
+	})
+}
+
+// redirectWdInFile performs the rewrite described in redirectWd for the provided file. wdImportPath is the import path
+// of the package written by redirectWd.
+func redirectWdInFile(fpath, wdImportPath string) error {
+	return rewriteFile(fpath, wdPackage, wdImportPath, func(fileNode *ast.File) bool {
+		replaced := replacePackageFuncs(fileNode, wdFuncs, wdPackage)
+		wrapped := wrapPackageCalls(fileNode, execFuncs, wdPackage, "Cmd")
+		return replaced || wrapped
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomate
+
+import "testing"
+
+func Test_redirectWdInFile(t *testing.T) {
+	runRewriteFileTests(t, []rewriteFileTestCase{
+		{
+			name: "rewrites functions that use the working directory",
+			src: `package foo
+
+import (
+	"os"
+	"path/filepath"
+)
+
+func F() ([]byte, error) {
+	abs, err := filepath.Abs("config.yml")
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(filepath.Dir(abs), "other.yml"))
+}
+`,
+			want: `package foo
+
+import (
+	"path/filepath"
+	amalgomated_wd "github.com/project/internal/github.com/tool/amalgomated_wd"
+)
+
+func F() ([]byte, error) {
+	abs, err := amalgomated_wd.Abs("config.yml")
+	if err != nil {
+		return nil, err
+	}
+	return amalgomated_wd.ReadFile(filepath.Join(filepath.Dir(abs), "other.yml"))
+}
+`,
+		},
+		{
+			name: "wraps commands",
+			src: `package foo
+
+import (
+	"context"
+	"os/exec"
+)
+
+func F(ctx context.Context) error {
+	if err := exec.Command("tool", "arg").Run(); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "tool")
+	return cmd.Run()
+}
+`,
+			want: `package foo
+
+import (
+	"context"
+	"os/exec"
+	amalgomated_wd "github.com/project/internal/github.com/tool/amalgomated_wd"
+)
+
+func F(ctx context.Context) error {
+	if err := amalgomated_wd.Cmd(exec.Command("tool", "arg")).Run(); err != nil {
+		return err
+	}
+	cmd := amalgomated_wd.Cmd(exec.CommandContext(ctx, "tool"))
+	return cmd.Run()
+}
+`,
+		},
+		{
+			name: "removes unused imports",
+			src: `package foo
+
+import (
+	"os"
+	"path/filepath"
+)
+
+func F() (string, error) {
+	if _, err := os.Getwd(); err != nil {
+		return "", err
+	}
+	return filepath.Abs(".")
+}
+`,
+			want: `package foo
+
+import amalgomated_wd "github.com/project/internal/github.com/tool/amalgomated_wd"
+
+func F() (string, error) {
+	if _, err := amalgomated_wd.Getwd(); err != nil {
+		return "", err
+	}
+	return amalgomated_wd.Abs(".")
+}
+`,
+		},
+	}, func(fpath string) error {
+		return redirectWdInFile(fpath, "github.com/project/internal/github.com/tool/amalgomated_wd")
+	})
+}
@@ -16,12 +16,13 @@ const ProxyCmdPrefix = "__"
 // first element contains the invoking executable command and the rest contain the elements). If there are flags that
 // can occur before the proxy command that should be ignored for the purposes of determining whether or not a command is
 // a proxy command, they should be provided in "fset". If osArgs[1] of the non-flag arguments exists and is a proxy
-// command, the corresponding command in cmdSet is run with the rest of the arguments and os.Exit is called. Otherwise,
-// the provided "app" function is run and its return value is returned.
+// command, the corresponding command in cmdSet is run with the rest of the arguments and its exit code is returned (if
+// cmdLibrary implements CmdLibraryWithResult, the code is the one returned by RunWithResult; otherwise, it is 0 if the
+// command returns). Otherwise, the provided "app" function is run and its return value is returned.
 func RunApp(osArgs []string, fset *flag.FlagSet, cmdLibrary CmdLibrary, app func(osArgs []string) int) int {
 	// process provided commands and process if it is a proxy command
-	if processProxyCmd(osArgs, fset, cmdLibrary) {
-		return 0
+	if proxied, exitCode := processProxyCmd(osArgs, fset, cmdLibrary); proxied {
+		return exitCode
 	}
 
 	// otherwise, run the provided application
@@ -30,10 +31,11 @@ func RunApp(osArgs []string, fset *flag.FlagSet, cmdLibrary CmdLibrary, app func
 
 // processProxyCmd checks the second non-flag element of the provided osArgs slice (which is the first argument to the
 // executable) to see if it is a proxy command. If it is, "os.Args" is set to be the non-proxy command arguments and the
-// un-proxied command is run; otherwise, it is a no-op. Returns true if the command was proxied and the proxied command
-// was run; false otherwise. Note that, if a proxied command is run, it is possible that the proxied command may call
-// some form of "os.Exit" itself. If this is the case, then this function will be terminal and will not return a value.
-func processProxyCmd(osArgs []string, fset *flag.FlagSet, cmdLibrary CmdLibrary) bool {
+// un-proxied command is run; otherwise, it is a no-op. Returns true and the exit code of the proxied command if the
+// command was proxied and the proxied command was run; false otherwise. Note that, if a proxied command is run, it is
+// possible that the proxied command may call some form of "os.Exit" itself. If this is the case, then this function
+// will be terminal and will not return a value.
+func processProxyCmd(osArgs []string, fset *flag.FlagSet, cmdLibrary CmdLibrary) (bool, int) {
 	// if fset is provided and is able to parse the arguments, parse the provided arguments and only consider the
 	// non-flag arguments when determining whether or not the arguments constitute a proxy command
 	if fset != nil && len(osArgs) > 0 {
@@ -44,7 +46,7 @@ func processProxyCmd(osArgs []string, fset *flag.FlagSet, cmdLibrary CmdLibrary)
 
 	if len(osArgs) <= 1 || !isProxyCmd(cmdType(osArgs[1])) {
 		// not a proxy command
-		return false
+		return false, 0
 	}
 
 	// get un-proxied command
@@ -55,12 +57,25 @@ func processProxyCmd(osArgs []string, fset *flag.FlagSet, cmdLibrary CmdLibrary)
 	os.Args = append(osArgs[:1], osArgs[2:]...)
 
 	// run command in-process. Calls into the wrapped "main" function, so it is possible/likely that the call will
-	// call os.Exit and terminate the program.
+	// call os.Exit and terminate the program. The concurrency policy of the command is not applied because the
+	// process was started to run the command (possibly by a library that runs the command in a subprocess).
+	if inProcessLibrary, ok := cmdLibrary.(inProcessCmdLibrary); ok {
+		return true, inProcessLibrary.runInProcess(cmd)
+	}
+	if resultLibrary, ok := cmdLibrary.(CmdLibraryWithResult); ok {
+		return true, resultLibrary.RunWithResult(cmd)
+	}
 	cmdLibrary.Run(cmd)
 
 	// if previous call completed, it means that it reached the end of the wrapped main method and os.Exit was not
 	// called. This is assumed to mean successful execution.
-	return true
+	return true, 0
+}
+
+// inProcessCmdLibrary is implemented by a CmdLibrary that can run a command in-process regardless of its concurrency
+// policy. The CmdLibrary returned by NewCmdLibrary implements this interface.
+type inProcessCmdLibrary interface {
+	runInProcess(cmd Cmd) int
 }
 
 type cmdType string
@@ -52,6 +52,15 @@ func TestRunApp(t *testing.T) {
 			expectedExitCode: 0,
 			expectedOutput:   "foo\n",
 		},
+		// running proxy command returns exit code of proxied command
+		{
+			args: []string{
+				"arg0",
+				amalgomated.ProxyCmdPrefix + "bar",
+			},
+			expectedExitCode: 3,
+			expectedOutput:   "bar\n",
+		},
 		// providing flag set ignores flag values
 		{
 			args: []string{
@@ -74,8 +83,13 @@ func TestRunApp(t *testing.T) {
 			fmt.Fprintln(runAppOutput, "foo")
 		})
 		require.NoError(t, err, "Case %d", i)
+		cmdWithResultRunner, err := amalgomated.NewCmdWithResultRunner("bar", func() int {
+			fmt.Fprintln(runAppOutput, "bar")
+			return 3
+		})
+		require.NoError(t, err, "Case %d", i)
 
-		cmdSet, err := amalgomated.NewStringCmdSetForRunners(cmdWithRunner)
+		cmdSet, err := amalgomated.NewStringCmdSetForRunners(cmdWithRunner, cmdWithResultRunner)
 		require.NoError(t, err, "Case %d", i)
 		cmdLibrary := amalgomated.NewCmdLibrary(cmdSet)
 		appFunc := func(osArgs []string) int {
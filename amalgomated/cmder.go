@@ -5,8 +5,10 @@
 package amalgomated
 
 import (
+	"context"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -21,6 +23,36 @@ type Cmder interface {
 	Cmd(args []string, cmdWd string) *exec.Cmd
 }
 
+// ContextCmder is a Cmder that can create commands that are bound to a context. The Cmders returned by the functions of
+// this package implement this interface.
+type ContextCmder interface {
+	Cmder
+	// CmdContext returns the same command as Cmd, except that the command is created using exec.CommandContext with
+	// the provided context, so that it is killed if the context is done before the command completes.
+	CmdContext(ctx context.Context, args []string, cmdWd string) *exec.Cmd
+}
+
+// CommandContext returns the command created by the provided Cmder for the provided arguments and working directory,
+// bound to the provided context. If the Cmder does not implement ContextCmder, the returned command cannot be started
+// and returns an error when it is run.
+func CommandContext(ctx context.Context, c Cmder, args []string, cmdWd string) *exec.Cmd {
+	if contextCmder, ok := c.(ContextCmder); ok {
+		return contextCmder.CmdContext(ctx, args, cmdWd)
+	}
+	cmd := c.Cmd(args, cmdWd)
+	cmd.Err = errors.Errorf("Cmder %T does not support contexts", c)
+	return cmd
+}
+
+// CommandTimeout returns the result of CommandContext with a context derived from the provided context that is done
+// when the provided timeout elapses. The timeout is measured from the time at which the command is created, so the
+// command should be started immediately. The returned function releases the resources of the context and should be
+// called once the command has completed (after Wait returns).
+func CommandTimeout(ctx context.Context, c Cmder, timeout time.Duration, args []string, cmdWd string) (*exec.Cmd, context.CancelFunc) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	return CommandContext(timeoutCtx, c, args, cmdWd), cancel
+}
+
 // CmderSupplier returns the Cmder that runs the specified command. Returns an error if a Runner cannot be created for
 // the requested Cmd.
 type CmderSupplier func(cmd Cmd) (Cmder, error)
@@ -72,6 +104,12 @@ func (r *runner) Cmd(args []string, cmdWd string) *exec.Cmd {
 	return cmd
 }
 
+func (r *runner) CmdContext(ctx context.Context, args []string, cmdWd string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, r.pathToExecutable, append(r.prependedArgs, args...)...)
+	cmd.Dir = cmdWd
+	return cmd
+}
+
 type wrappedCmder struct {
 	inner         Cmder
 	prependedArgs []string
@@ -82,6 +120,11 @@ func (r *wrappedCmder) Cmd(args []string, cmdWd string) *exec.Cmd {
 	return r.inner.Cmd(combinedArgs, cmdWd)
 }
 
+func (r *wrappedCmder) CmdContext(ctx context.Context, args []string, cmdWd string) *exec.Cmd {
+	combinedArgs := append(append(make([]string, 0, len(r.prependedArgs)+len(args)), r.prependedArgs...), args...)
+	return CommandContext(ctx, r.inner, combinedArgs, cmdWd)
+}
+
 // selfCmder returns a Cmder that creates a command that re-invokes the currently running executable.
 func selfCmder() (Cmder, error) {
 	pathToSelf, err := os.Executable()
@@ -0,0 +1,176 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomated
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// CmdOption configures the *exec.Cmd that is returned by a Cmder created by CmderWithOptions.
+type CmdOption func(*cmdOptions)
+
+type cmdOptions struct {
+	env          []string
+	cleanEnv     bool
+	stdin        io.Reader
+	stdout       io.Writer
+	stderr       io.Writer
+	waitDelay    time.Duration
+	processGroup bool
+}
+
+// WithEnv returns an option that adds the provided environment variables (of the form "KEY=value") to the environment
+// of the command. Unless WithCleanEnv is provided, the variables are added to the environment that the command would
+// otherwise have (the environment of the process if it was not set), and they take precedence over variables with the
+// same keys.
+func WithEnv(env ...string) CmdOption {
+	return func(o *cmdOptions) {
+		o.env = append(o.env, env...)
+	}
+}
+
+// WithCleanEnv returns an option that runs the command with an environment that only contains the variables provided
+// using WithEnv.
+func WithCleanEnv() CmdOption {
+	return func(o *cmdOptions) {
+		o.cleanEnv = true
+	}
+}
+
+// WithStdio returns an option that connects the standard streams of the command to the provided reader and writers.
+// Streams that are nil are not changed.
+func WithStdio(stdin io.Reader, stdout, stderr io.Writer) CmdOption {
+	return func(o *cmdOptions) {
+		if stdin != nil {
+			o.stdin = stdin
+		}
+		if stdout != nil {
+			o.stdout = stdout
+		}
+		if stderr != nil {
+			o.stderr = stderr
+		}
+	}
+}
+
+// WithWaitDelay returns an option that sets the WaitDelay of the command: once the command has been killed because its
+// context is done (or it has exited), Wait waits at most the provided duration for its output to be copied before
+// closing the pipes of the command.
+func WithWaitDelay(waitDelay time.Duration) CmdOption {
+	return func(o *cmdOptions) {
+		o.waitDelay = waitDelay
+	}
+}
+
+// WithProcessGroup returns an option that starts the command in its own process group. If the command is bound to a
+// context (see CommandContext) and the context is done, the entire process group (which includes the processes started
+// by the command) is killed on platforms that support it. The process group of a running command can also be killed
+// using KillProcessGroup.
+func WithProcessGroup() CmdOption {
+	return func(o *cmdOptions) {
+		o.processGroup = true
+	}
+}
+
+// KillProcessGroup kills the process group of the provided command, which must have been started with the option
+// returned by WithProcessGroup. On platforms that do not support process groups, only the process of the command is
+// killed.
+func KillProcessGroup(cmd *exec.Cmd) error {
+	return killProcessGroup(cmd)
+}
+
+type optionsCmder struct {
+	inner Cmder
+	opts  []CmdOption
+}
+
+func (c *optionsCmder) Cmd(args []string, cmdWd string) *exec.Cmd {
+	return c.apply(c.inner.Cmd(args, cmdWd))
+}
+
+func (c *optionsCmder) CmdContext(ctx context.Context, args []string, cmdWd string) *exec.Cmd {
+	return c.apply(CommandContext(ctx, c.inner, args, cmdWd))
+}
+
+// apply configures the provided command using the options of the Cmder and returns it.
+func (c *optionsCmder) apply(cmd *exec.Cmd) *exec.Cmd {
+	var o cmdOptions
+	for _, opt := range c.opts {
+		opt(&o)
+	}
+
+	if o.cleanEnv || len(o.env) > 0 {
+		env := cmd.Env
+		if o.cleanEnv {
+			env = []string{}
+		} else if env == nil {
+			env = os.Environ()
+		}
+		cmd.Env = append(env, o.env...)
+	}
+
+	if o.stdin != nil {
+		cmd.Stdin = o.stdin
+	}
+	if o.stdout != nil {
+		cmd.Stdout = o.stdout
+	}
+	if o.stderr != nil {
+		cmd.Stderr = o.stderr
+	}
+
+	if o.waitDelay > 0 {
+		cmd.WaitDelay = o.waitDelay
+	}
+
+	if o.processGroup {
+		setProcessGroup(cmd)
+		// Cancel is only set (and only called) if the command is bound to a context
+		if cmd.Cancel != nil {
+			cmd.Cancel = func() error {
+				return killProcessGroup(cmd)
+			}
+		}
+	}
+	return cmd
+}
+
+// CmderWithOptions returns a new Cmder that invokes the provided Cmder and configures the returned command using the
+// provided options.
+func CmderWithOptions(c Cmder, opts ...CmdOption) Cmder {
+	return &optionsCmder{
+		inner: c,
+		opts:  opts,
+	}
+}
+
+// PathCmderWithOptions returns a Cmder that behaves like the Cmder returned by PathCmder for the provided path and
+// "prependedArgs" and that configures the returned command using the provided options.
+func PathCmderWithOptions(pathToExecutable string, prependedArgs []string, opts ...CmdOption) Cmder {
+	return CmderWithOptions(PathCmder(pathToExecutable, prependedArgs...), opts...)
+}
+
+// CmderWithPrependedArgsAndOptions returns a Cmder that behaves like the Cmder returned by CmderWithPrependedArgs for
+// the provided Cmder and "prependedArgs" and that configures the returned command using the provided options.
+func CmderWithPrependedArgsAndOptions(r Cmder, prependedArgs []string, opts ...CmdOption) Cmder {
+	return CmderWithOptions(CmderWithPrependedArgs(r, prependedArgs...), opts...)
+}
+
+// SelfProxyCmderSupplierWithOptions returns a supplier that behaves like the supplier returned by
+// SelfProxyCmderSupplier and whose Cmders configure the returned command using the provided options.
+func SelfProxyCmderSupplierWithOptions(opts ...CmdOption) CmderSupplier {
+	supplier := SelfProxyCmderSupplier()
+	return func(cmd Cmd) (Cmder, error) {
+		cmder, err := supplier(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return CmderWithOptions(cmder, opts...), nil
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package amalgomated_test
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/palantir/amalgomate/amalgomated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCmderWithOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX shell commands")
+	}
+
+	for i, currCase := range []struct {
+		name           string
+		cmder          func() amalgomated.Cmder
+		args           []string
+		stdin          string
+		expectedOutput string
+	}{
+		{
+			name: "environment variables are added to environment of process",
+			cmder: func() amalgomated.Cmder {
+				return amalgomated.PathCmderWithOptions("sh", []string{"-c"}, amalgomated.WithEnv("AMALGOMATED_TEST_VAR=foo"))
+			},
+			args:           []string{`echo "$AMALGOMATED_TEST_VAR" "$PATH"`},
+			expectedOutput: "foo " + strings.TrimSpace(mustOutput(t, "sh", "-c", `echo "$PATH"`)) + "\n",
+		},
+		{
+			name: "clean environment contains only provided variables",
+			cmder: func() amalgomated.Cmder {
+				return amalgomated.PathCmderWithOptions("env", nil, amalgomated.WithCleanEnv(), amalgomated.WithEnv("FOO=bar"))
+			},
+			expectedOutput: "FOO=bar\n",
+		},
+		{
+			name: "stdin is connected to provided reader",
+			cmder: func() amalgomated.Cmder {
+				return amalgomated.PathCmderWithOptions("cat", nil, amalgomated.WithStdio(strings.NewReader("input"), nil, nil))
+			},
+			expectedOutput: "input",
+		},
+		{
+			name: "options are applied to cmder with prepended args",
+			cmder: func() amalgomated.Cmder {
+				return amalgomated.CmderWithPrependedArgsAndOptions(amalgomated.PathCmder("sh"), []string{"-c", `echo "$FOO" "$@"`, "sh"}, amalgomated.WithEnv("FOO=bar"))
+			},
+			args:           []string{"provided"},
+			expectedOutput: "bar provided\n",
+		},
+	} {
+		cmd := currCase.cmder().Cmd(currCase.args, "")
+		output, err := cmd.Output()
+		require.NoError(t, err, "Case %d: %s", i, currCase.name)
+		assert.Equal(t, currCase.expectedOutput, string(output), "Case %d: %s", i, currCase.name)
+	}
+}
+
+func TestCmderWithOptionsStdout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX shell commands")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := amalgomated.PathCmderWithOptions("sh", []string{"-c"}, amalgomated.WithStdio(nil, &stdout, &stderr)).Cmd([]string{"echo out; echo err >&2"}, "")
+	require.NoError(t, cmd.Run())
+	assert.Equal(t, "out\n", stdout.String())
+	assert.Equal(t, "err\n", stderr.String())
+}
+
+func TestCmderWithOptionsContext(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX shell commands")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cmder := amalgomated.CmderWithPrependedArgsAndOptions(amalgomated.PathCmder("sleep"), nil, amalgomated.WithWaitDelay(time.Second))
+	cmd := amalgomated.CommandContext(ctx, cmder, []string{"30"}, "")
+	assert.Equal(t, time.Second, cmd.WaitDelay)
+	err := cmd.Run()
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCommandContextUnsupportedCmder(t *testing.T) {
+	cmder := amalgomated.CmderWithPrependedArgs((*testCmder)(exec.Command("/bin/test")), "prepended")
+	cmd := amalgomated.CommandContext(context.Background(), cmder, nil, "")
+	assert.EqualError(t, cmd.Run(), "Cmder *amalgomated_test.testCmder does not support contexts")
+}
+
+func TestCmderWithOptionsTimeoutKillsProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses POSIX shell commands")
+	}
+
+	// the background process keeps the output pipe open, so the command only completes before the sleep does if the
+	// entire process group is killed
+	var stdout bytes.Buffer
+	cmder := amalgomated.PathCmderWithOptions("sh", []string{"-c"},
+		amalgomated.WithProcessGroup(),
+		amalgomated.WithStdio(nil, &stdout, nil),
+	)
+	cmd, cancel := amalgomated.CommandTimeout(context.Background(), cmder, 100*time.Millisecond, []string{"sleep 30 & wait"}, "")
+	defer cancel()
+
+	start := time.Now()
+	err := cmd.Run()
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+func mustOutput(t *testing.T, name string, args ...string) string {
+	output, err := exec.Command(name, args...).Output()
+	require.NoError(t, err)
+	return string(output)
+}
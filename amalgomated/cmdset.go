@@ -6,7 +6,10 @@ package amalgomated
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
 	"slices"
+	"sync"
 	"unicode"
 
 	"github.com/pkg/errors"
@@ -18,19 +21,97 @@ type StringCmdSet interface {
 	Cmds() []string
 }
 
+// StringCmdSetWithResult is a StringCmdSet whose commands can report an exit code.
+type StringCmdSetWithResult interface {
+	StringCmdSet
+	// RunWithResult runs the provided command and returns its exit code.
+	RunWithResult(cmd string) int
+}
+
+// ConcurrencyPolicy determines how a CmdLibrary runs a command that is run by multiple goroutines at the same time.
+type ConcurrencyPolicy int
+
+const (
+	// ConcurrencyParallel runs every invocation of the command in-process without any coordination. It should only be
+	// used for commands whose runners are reentrant. This is the default policy.
+	ConcurrencyParallel ConcurrencyPolicy = iota
+	// ConcurrencySerialize runs the command in-process, but only one invocation at a time: invocations that are made
+	// while the command is running wait until the running invocation completes.
+	ConcurrencySerialize
+	// ConcurrencySerializeOrSubprocess runs the command in-process if it is not already running. Invocations that are
+	// made while the command is running are run in a subprocess using SelfProxyCmderSupplier instead of waiting.
+	ConcurrencySerializeOrSubprocess
+	// ConcurrencySubprocess runs every invocation of the command in a subprocess using SelfProxyCmderSupplier.
+	ConcurrencySubprocess
+)
+
 // CmdWithRunner pairs a named command with the function for the command.
 type CmdWithRunner struct {
 	cmdName string
-	runner  func()
+	runner  func() int
+	policy  ConcurrencyPolicy
 }
 
 func (c *CmdWithRunner) Name() string {
 	return c.cmdName
 }
 
-// NewCmdWithRunner creates a new CmdWithRunner for the provided name and runner. Returns an error if the provided name
-// is not a legal command name.
+// ConcurrencyPolicy returns the policy that determines how concurrent invocations of the command are run.
+func (c *CmdWithRunner) ConcurrencyPolicy() ConcurrencyPolicy {
+	return c.policy
+}
+
+// NewCmdWithRunner creates a new CmdWithRunner for the provided name and runner that uses the ConcurrencyParallel
+// policy. Returns an error if the provided name is not a legal command name.
 func NewCmdWithRunner(cmdName string, runner func()) (*CmdWithRunner, error) {
+	return NewCmdWithRunnerAndPolicy(cmdName, runner, ConcurrencyParallel)
+}
+
+// MustNewCmdWithRunner returns the result of NewCmdWithRunner and panics in cases where the function returns an error.
+func MustNewCmdWithRunner(cmdName string, runner func()) *CmdWithRunner {
+	return MustNewCmdWithRunnerAndPolicy(cmdName, runner, ConcurrencyParallel)
+}
+
+// NewCmdWithRunnerAndPolicy creates a new CmdWithRunner for the provided name and runner that uses the provided
+// concurrency policy. Returns an error if the provided name is not a legal command name or if the policy is not valid.
+func NewCmdWithRunnerAndPolicy(cmdName string, runner func(), policy ConcurrencyPolicy) (*CmdWithRunner, error) {
+	var resultRunner func() int
+	if runner != nil {
+		resultRunner = func() int {
+			runner()
+			return 0
+		}
+	}
+	return NewCmdWithResultRunnerAndPolicy(cmdName, resultRunner, policy)
+}
+
+// MustNewCmdWithRunnerAndPolicy returns the result of NewCmdWithRunnerAndPolicy and panics in cases where the function
+// returns an error.
+func MustNewCmdWithRunnerAndPolicy(cmdName string, runner func(), policy ConcurrencyPolicy) *CmdWithRunner {
+	cmdWithRunner, err := NewCmdWithRunnerAndPolicy(cmdName, runner, policy)
+	if err != nil {
+		panic(err)
+	}
+	return cmdWithRunner
+}
+
+// NewCmdWithResultRunner creates a new CmdWithRunner for the provided name and runner that uses the
+// ConcurrencyParallel policy. The value returned by the runner is the exit code of the command. Returns an error if the
+// provided name is not a legal command name.
+func NewCmdWithResultRunner(cmdName string, runner func() int) (*CmdWithRunner, error) {
+	return NewCmdWithResultRunnerAndPolicy(cmdName, runner, ConcurrencyParallel)
+}
+
+// MustNewCmdWithResultRunner returns the result of NewCmdWithResultRunner and panics in cases where the function returns
+// an error.
+func MustNewCmdWithResultRunner(cmdName string, runner func() int) *CmdWithRunner {
+	return MustNewCmdWithResultRunnerAndPolicy(cmdName, runner, ConcurrencyParallel)
+}
+
+// NewCmdWithResultRunnerAndPolicy creates a new CmdWithRunner for the provided name and runner that uses the provided
+// concurrency policy. The value returned by the runner is the exit code of the command. Returns an error if the provided
+// name is not a legal command name or if the policy is not valid.
+func NewCmdWithResultRunnerAndPolicy(cmdName string, runner func() int, policy ConcurrencyPolicy) (*CmdWithRunner, error) {
 	if cmdName == "" {
 		return nil, errors.New("cmdName cannot be blank")
 	}
@@ -41,15 +122,21 @@ func NewCmdWithRunner(cmdName string, runner func()) (*CmdWithRunner, error) {
 		}
 	}
 
+	if policy < ConcurrencyParallel || policy > ConcurrencySubprocess {
+		return nil, errors.Errorf("invalid concurrency policy: %d", policy)
+	}
+
 	return &CmdWithRunner{
 		cmdName: cmdName,
 		runner:  runner,
+		policy:  policy,
 	}, nil
 }
 
-// MustNewCmdWithRunner returns the result of NewCmdWithRunner and panics in cases where the function returns an error.
-func MustNewCmdWithRunner(cmdName string, runner func()) *CmdWithRunner {
-	cmdWithRunner, err := NewCmdWithRunner(cmdName, runner)
+// MustNewCmdWithResultRunnerAndPolicy returns the result of NewCmdWithResultRunnerAndPolicy and panics in cases where
+// the function returns an error.
+func MustNewCmdWithResultRunnerAndPolicy(cmdName string, runner func() int, policy ConcurrencyPolicy) *CmdWithRunner {
+	cmdWithRunner, err := NewCmdWithResultRunnerAndPolicy(cmdName, runner, policy)
 	if err != nil {
 		panic(err)
 	}
@@ -59,15 +146,29 @@ func MustNewCmdWithRunner(cmdName string, runner func()) *CmdWithRunner {
 type cmdWithRunnerCmdSet []*CmdWithRunner
 
 func (s cmdWithRunnerCmdSet) Run(cmd string) {
+	_ = s.RunWithResult(cmd)
+}
+
+func (s cmdWithRunnerCmdSet) RunWithResult(cmd string) int {
 	for _, curr := range s {
 		if cmd == curr.cmdName {
-			curr.runner()
-			return
+			return curr.runner()
 		}
 	}
 	panic(fmt.Sprintf("cmd %v not found in %v", cmd, s))
 }
 
+// concurrencyPolicy returns the concurrency policy of the provided command, or ConcurrencyParallel if the set does not
+// contain the command.
+func (s cmdWithRunnerCmdSet) concurrencyPolicy(cmd string) ConcurrencyPolicy {
+	for _, curr := range s {
+		if cmd == curr.cmdName {
+			return curr.policy
+		}
+	}
+	return ConcurrencyParallel
+}
+
 func (s cmdWithRunnerCmdSet) Cmds() []string {
 	cmds := make([]string, len(s))
 	for i := range s {
@@ -111,18 +212,168 @@ type CmdLibrary interface {
 	MustNewCmd(cmd string) Cmd
 }
 
+// CmdLibraryWithResult is a CmdLibrary whose commands can report an exit code. The CmdLibrary returned by NewCmdLibrary
+// implements this interface. Only the runners of commands report exit codes: a command whose runner returns without a
+// result (such as one that calls the Run function of a generated library) reports 0, and a command that calls os.Exit
+// while running in-process terminates the process.
+type CmdLibraryWithResult interface {
+	CmdLibrary
+	// RunWithResult runs the provided command and returns its exit code.
+	RunWithResult(cmd Cmd) int
+	// RunWithArgs runs the provided command with the provided arguments (which do not include the executable) and
+	// returns its exit code.
+	RunWithArgs(cmd Cmd, args []string) int
+}
+
+// concurrencyPolicySet is implemented by a StringCmdSet whose commands have concurrency policies. The commands of a
+// StringCmdSet that does not implement the interface use the ConcurrencyParallel policy.
+type concurrencyPolicySet interface {
+	concurrencyPolicy(cmd string) ConcurrencyPolicy
+}
+
+// argsLock is held by the in-process invocations that set os.Args. os.Args is shared by all of the commands of the
+// process, so these invocations are serialized regardless of the concurrency policies of their commands.
+var argsLock sync.Mutex
+
 type cmdLibraryImpl struct {
 	cmdSet StringCmdSet
+	// subprocessCmderSupplier supplies the Cmder used to run commands in a subprocess.
+	subprocessCmderSupplier CmderSupplier
+
+	mu sync.Mutex
+	// cmdLocks contains the locks that serialize the in-process invocations of the commands.
+	cmdLocks map[string]*sync.Mutex
 }
 
+// NewCmdLibrary creates a new CmdLibrary for the provided commands. If cmdSet was created by
+// NewStringCmdSetForRunners, the library runs the commands according to their concurrency policies.
 func NewCmdLibrary(cmdSet StringCmdSet) CmdLibrary {
 	return &cmdLibraryImpl{
-		cmdSet: cmdSet,
+		cmdSet:                  cmdSet,
+		subprocessCmderSupplier: SelfProxyCmderSupplier(),
+		cmdLocks:                make(map[string]*sync.Mutex),
 	}
 }
 
+// Run runs the provided command according to its concurrency policy. The exit code of the command is discarded: use
+// RunWithResult to obtain it.
 func (c *cmdLibraryImpl) Run(cmd Cmd) {
+	_ = c.RunWithResult(cmd)
+}
+
+// RunWithResult runs the provided command according to its concurrency policy and returns its exit code. An in-process
+// invocation reads its arguments from os.Args as set by the caller, and a subprocess is run with the arguments in
+// os.Args at the time of the call. The exit codes are those described in RunWithArgs.
+func (c *cmdLibraryImpl) RunWithResult(cmd Cmd) int {
+	var args []string
+	if len(os.Args) > 1 {
+		args = slices.Clone(os.Args[1:])
+	}
+	return c.run(cmd, args, false)
+}
+
+// RunWithArgs runs the provided command with the provided arguments according to its concurrency policy and returns its
+// exit code. Because in-process invocations read their arguments from os.Args, os.Args is set to the arguments for the
+// duration of an in-process invocation. os.Args is shared by the whole process, so the in-process invocations made
+// using RunWithArgs are run one at a time (even if their commands use the ConcurrencyParallel policy or are provided by
+// different libraries): an invocation of a command that uses the ConcurrencySerializeOrSubprocess policy is run in a
+// subprocess while another such invocation is running and other invocations wait. A command must therefore not use
+// RunWithArgs to run another command in-process while it is running. If the command is run in-process, the exit code is
+// the value returned by its runner if the command set implements StringCmdSetWithResult and 0 otherwise. If the command
+// is run in a subprocess, the subprocess is connected to the standard streams of the process and the exit code is the
+// exit code of the subprocess. If the subprocess cannot be run or is terminated by a signal, the error is written to
+// os.Stderr and 1 is returned.
+func (c *cmdLibraryImpl) RunWithArgs(cmd Cmd, args []string) int {
+	return c.run(cmd, args, true)
+}
+
+// run runs the provided command according to its concurrency policy. If setArgs is true, os.Args is set to the provided
+// arguments for the duration of an in-process invocation.
+func (c *cmdLibraryImpl) run(cmd Cmd, args []string, setArgs bool) int {
+	policy := ConcurrencyParallel
+	if policySet, ok := c.cmdSet.(concurrencyPolicySet); ok {
+		policy = policySet.concurrencyPolicy(cmd.Name())
+	}
+
+	switch policy {
+	case ConcurrencySerialize:
+		lock := c.cmdLock(cmd)
+		lock.Lock()
+		defer lock.Unlock()
+	case ConcurrencySerializeOrSubprocess:
+		lock := c.cmdLock(cmd)
+		if !lock.TryLock() {
+			return c.runSubprocess(cmd, args)
+		}
+		defer lock.Unlock()
+	case ConcurrencySubprocess:
+		return c.runSubprocess(cmd, args)
+	}
+	if setArgs {
+		if policy == ConcurrencySerializeOrSubprocess {
+			if !argsLock.TryLock() {
+				return c.runSubprocess(cmd, args)
+			}
+		} else {
+			argsLock.Lock()
+		}
+		defer argsLock.Unlock()
+		origArgs := os.Args
+		os.Args = append([]string{origArgs[0]}, args...)
+		defer func() {
+			os.Args = origArgs
+		}()
+	}
+	return c.runInProcess(cmd)
+}
+
+// runInProcess runs the provided command in-process regardless of its concurrency policy and returns its exit code. It
+// is used to run proxy commands, which are run in a subprocess that was started to run the command.
+func (c *cmdLibraryImpl) runInProcess(cmd Cmd) int {
+	if cmdSet, ok := c.cmdSet.(StringCmdSetWithResult); ok {
+		return cmdSet.RunWithResult(cmd.Name())
+	}
 	c.cmdSet.Run(cmd.Name())
+	return 0
+}
+
+func (c *cmdLibraryImpl) cmdLock(cmd Cmd) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lock, ok := c.cmdLocks[cmd.Name()]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.cmdLocks[cmd.Name()] = lock
+	}
+	return lock
+}
+
+// runSubprocess runs the provided command with the provided arguments in a subprocess and returns its exit code. If the
+// subprocess cannot be run or is terminated by a signal, the error is written to os.Stderr and 1 is returned.
+func (c *cmdLibraryImpl) runSubprocess(cmd Cmd, args []string) int {
+	exitCode, err := c.runSubprocessWithError(cmd, args)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return exitCode
+}
+
+func (c *cmdLibraryImpl) runSubprocessWithError(cmd Cmd, args []string) (int, error) {
+	cmder, err := c.subprocessCmderSupplier(cmd)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to create Cmder for command %s", cmd.Name())
+	}
+	execCmd := cmder.Cmd(args, "")
+	execCmd.Stdin, execCmd.Stdout, execCmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := execCmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+			return exitErr.ExitCode(), nil
+		}
+		// the exit code of a process that was terminated by a signal is -1
+		return 0, errors.Wrapf(err, "failed to run command %s in a subprocess", cmd.Name())
+	}
+	return 0, nil
 }
 
 func (c *cmdLibraryImpl) Cmds() []Cmd {
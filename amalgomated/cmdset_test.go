@@ -5,7 +5,14 @@
 package amalgomated_test
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/palantir/amalgomate/amalgomated"
 	"github.com/stretchr/testify/assert"
@@ -36,6 +43,224 @@ func TestNewCmdWithRunner(t *testing.T) {
 	}
 }
 
+// TestMain runs the commands of subprocessTestLibrary when the test binary is invoked with a proxy command so that
+// the library can run them in a subprocess.
+func TestMain(m *testing.M) {
+	os.Exit(amalgomated.RunApp(os.Args, nil, subprocessTestLibrary(nil, nil), func([]string) int {
+		return m.Run()
+	}))
+}
+
+// subprocessTestLibrary returns a library with commands that print their arguments and the process in which they are
+// run. If started is non-nil, the commands send a value to it when they start running. If block is non-nil, the
+// commands block until it is closed.
+func subprocessTestLibrary(started chan<- struct{}, block <-chan struct{}) amalgomated.CmdLibrary {
+	var cmds []*amalgomated.CmdWithRunner
+	for _, policy := range []struct {
+		name   string
+		policy amalgomated.ConcurrencyPolicy
+	}{
+		{name: "serialize-or-subprocess", policy: amalgomated.ConcurrencySerializeOrSubprocess},
+		{name: "subprocess", policy: amalgomated.ConcurrencySubprocess},
+	} {
+		cmds = append(cmds, amalgomated.MustNewCmdWithRunnerAndPolicy(policy.name, func() {
+			if started != nil {
+				started <- struct{}{}
+			}
+			if block != nil {
+				<-block
+			}
+			fmt.Printf("%s %v %d\n", policy.name, os.Args[1:], os.Getpid())
+		}, policy.policy))
+	}
+	cmds = append(cmds, amalgomated.MustNewCmdWithResultRunnerAndPolicy("fail", func() int {
+		return 5
+	}, amalgomated.ConcurrencySubprocess))
+	cmds = append(cmds, amalgomated.MustNewCmdWithRunnerAndPolicy("killed", func() {
+		self, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			_ = self.Kill()
+		}
+		select {}
+	}, amalgomated.ConcurrencySubprocess))
+	cmdSet, err := amalgomated.NewStringCmdSetForRunners(cmds...)
+	if err != nil {
+		panic(err)
+	}
+	return amalgomated.NewCmdLibrary(cmdSet)
+}
+
+func TestNewCmdWithRunnerAndPolicyInvalidPolicy(t *testing.T) {
+	_, err := amalgomated.NewCmdWithRunnerAndPolicy("foo", nil, amalgomated.ConcurrencyPolicy(42))
+	assert.EqualError(t, err, "invalid concurrency policy: 42")
+}
+
+func TestCmdLibraryRunConcurrencyPolicy(t *testing.T) {
+	const numInvocations = 4
+	for i, currCase := range []struct {
+		policy            amalgomated.ConcurrencyPolicy
+		expectedMaxActive int32
+	}{
+		// invocations of a parallel command run at the same time
+		{policy: amalgomated.ConcurrencyParallel, expectedMaxActive: numInvocations},
+		// invocations of a serialized command run one at a time
+		{policy: amalgomated.ConcurrencySerialize, expectedMaxActive: 1},
+	} {
+		var active, maxActive int32
+		var started sync.WaitGroup
+		started.Add(numInvocations)
+		cmdWithRunner, err := amalgomated.NewCmdWithRunnerAndPolicy("foo", func() {
+			curr := atomic.AddInt32(&active, 1)
+			for {
+				prevMax := atomic.LoadInt32(&maxActive)
+				if curr <= prevMax || atomic.CompareAndSwapInt32(&maxActive, prevMax, curr) {
+					break
+				}
+			}
+			if currCase.policy == amalgomated.ConcurrencyParallel {
+				// wait until every invocation has started so that they are all active at the same time
+				started.Done()
+				started.Wait()
+			} else {
+				time.Sleep(10 * time.Millisecond)
+			}
+			atomic.AddInt32(&active, -1)
+		}, currCase.policy)
+		require.NoError(t, err, "Case %d", i)
+		cmdSet, err := amalgomated.NewStringCmdSetForRunners(cmdWithRunner)
+		require.NoError(t, err, "Case %d", i)
+		cmdLibrary := amalgomated.NewCmdLibrary(cmdSet)
+
+		var wg sync.WaitGroup
+		for range numInvocations {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cmdLibrary.Run(cmdLibrary.MustNewCmd("foo"))
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, currCase.expectedMaxActive, maxActive, "Case %d", i)
+	}
+}
+
+// TestCmdLibraryRunWithArgsParallel verifies that concurrent in-process invocations made using RunWithArgs each see
+// their own arguments in os.Args, even if their command uses the ConcurrencyParallel policy. Run with -race to verify
+// that os.Args is not accessed concurrently.
+func TestCmdLibraryRunWithArgsParallel(t *testing.T) {
+	const numInvocations = 4
+	var mu sync.Mutex
+	var gotArgs []string
+	cmdWithRunner, err := amalgomated.NewCmdWithRunner("foo", func() {
+		args := strings.Join(os.Args[1:], " ")
+		time.Sleep(10 * time.Millisecond)
+		if strings.Join(os.Args[1:], " ") != args {
+			args = "changed while running"
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		gotArgs = append(gotArgs, args)
+	})
+	require.NoError(t, err)
+	cmdSet, err := amalgomated.NewStringCmdSetForRunners(cmdWithRunner)
+	require.NoError(t, err)
+	cmdLibrary, ok := amalgomated.NewCmdLibrary(cmdSet).(amalgomated.CmdLibraryWithResult)
+	require.True(t, ok)
+
+	var wg sync.WaitGroup
+	var wantArgs []string
+	for i := range numInvocations {
+		args := []string{"invocation", fmt.Sprint(i)}
+		wantArgs = append(wantArgs, strings.Join(args, " "))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmdLibrary.RunWithArgs(cmdLibrary.MustNewCmd("foo"), args)
+		}()
+	}
+	wg.Wait()
+
+	assert.ElementsMatch(t, wantArgs, gotArgs)
+}
+
+func TestCmdLibraryRunSubprocess(t *testing.T) {
+	started := make(chan struct{})
+	block := make(chan struct{})
+	cmdLibrary, ok := subprocessTestLibrary(started, block).(amalgomated.CmdLibraryWithResult)
+	require.True(t, ok)
+	args := []string{"arg1", "arg2"}
+
+	output := captureStdout(t, func() {
+		// command with the subprocess policy is always run in a subprocess
+		assert.Equal(t, 0, cmdLibrary.RunWithArgs(cmdLibrary.MustNewCmd("subprocess"), args))
+
+		// command with the serialize-or-subprocess policy is run in a subprocess while it is running in-process
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmdLibrary.RunWithArgs(cmdLibrary.MustNewCmd("serialize-or-subprocess"), args)
+		}()
+		// the runner starts once the in-process invocation holds the lock
+		<-started
+		assert.Equal(t, 0, cmdLibrary.RunWithArgs(cmdLibrary.MustNewCmd("serialize-or-subprocess"), args))
+		close(block)
+		wg.Wait()
+	})
+
+	pid := os.Getpid()
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	require.Len(t, lines, 3, "Output: %s", output)
+	assert.Regexp(t, `^subprocess \[arg1 arg2\] [0-9]+$`, lines[0])
+	assert.NotEqual(t, fmt.Sprintf("subprocess [arg1 arg2] %d", pid), lines[0])
+	assert.Regexp(t, `^serialize-or-subprocess \[arg1 arg2\] [0-9]+$`, lines[1])
+	assert.NotEqual(t, fmt.Sprintf("serialize-or-subprocess [arg1 arg2] %d", pid), lines[1])
+	assert.Equal(t, fmt.Sprintf("serialize-or-subprocess [arg1 arg2] %d", pid), lines[2])
+}
+
+func TestCmdLibraryRunWithResult(t *testing.T) {
+	cmdWithRunner, err := amalgomated.NewCmdWithResultRunner("foo", func() int {
+		return 7
+	})
+	require.NoError(t, err)
+	cmdSet, err := amalgomated.NewStringCmdSetForRunners(cmdWithRunner)
+	require.NoError(t, err)
+	cmdLibrary, ok := amalgomated.NewCmdLibrary(cmdSet).(amalgomated.CmdLibraryWithResult)
+	require.True(t, ok)
+
+	// exit code of command run in-process is the value returned by its runner
+	assert.Equal(t, 7, cmdLibrary.RunWithResult(cmdLibrary.MustNewCmd("foo")))
+
+	// exit code of command run in a subprocess is the exit code of the subprocess
+	subprocessLibrary, ok := subprocessTestLibrary(nil, nil).(amalgomated.CmdLibraryWithResult)
+	require.True(t, ok)
+	assert.Equal(t, 5, subprocessLibrary.RunWithArgs(subprocessLibrary.MustNewCmd("fail"), nil))
+
+	// command run in a subprocess that is terminated by a signal fails without panicking
+	assert.Equal(t, 1, subprocessLibrary.RunWithArgs(subprocessLibrary.MustNewCmd("killed"), nil))
+}
+
+// captureStdout returns the output written to os.Stdout (including by subprocesses that inherit it) while f runs.
+func captureStdout(t *testing.T, f func()) string {
+	pr, pw, err := os.Pipe()
+	require.NoError(t, err)
+	origStdout := os.Stdout
+	os.Stdout = pw
+	defer func() {
+		os.Stdout = origStdout
+	}()
+
+	outputCh := make(chan string)
+	go func() {
+		output, _ := io.ReadAll(pr)
+		outputCh <- string(output)
+	}()
+	f()
+	require.NoError(t, pw.Close())
+	return <-outputCh
+}
+
 func TestCmdLibraryNewCmd(t *testing.T) {
 	runner, err := amalgomated.NewCmdWithRunner("foo", nil)
 	require.NoError(t, err)
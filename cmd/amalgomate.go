@@ -15,13 +15,15 @@ const (
 	configFlagName    = "config"
 	outputDirFlagName = "output-dir"
 	pkgFlagName       = "pkg"
+	overlayFlagName   = "overlay"
 )
 
 var (
-	debugFlagVal  bool
-	configFlagVal string
-	outputDirVal  string
-	pkgFlagVal    string
+	debugFlagVal   bool
+	configFlagVal  string
+	outputDirVal   string
+	pkgFlagVal     string
+	overlayFlagVal []string
 )
 
 // AmalgomateCmd represents the base command when called without any subcommands
@@ -32,9 +34,11 @@ var AmalgomateCmd = &cobra.Command{
 a "main" package into a library package that can be called
 directly in-process.
 
-amalgomate requires a configuration YML file that specifies
+amalgomate requires a configuration file that specifies
 the packages that should be converted from "main" packages
-into library packages. An output directory and the name of
+into library packages. The configuration file is read as
+JSON if it has the extension ".json", as TOML if it has the
+extension ".toml" and as YAML otherwise. An output directory and the name of
 the package for the generated source files should also be
 specified.
 
@@ -54,7 +58,7 @@ This invocation would amalgomate the inputs specified in "config.yml" and would
 write the generated source into the "generated_src" directory with the package
 name "amalgomated".`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := amalgomate.LoadConfig(configFlagVal)
+		cfg, err := amalgomate.LoadConfig(configFlagVal, overlayFlagVal...)
 		if err != nil {
 			return err
 		}
@@ -74,6 +78,8 @@ func init() {
 		panic(err)
 	}
 
+	AmalgomateCmd.Flags().StringSliceVar(&overlayFlagVal, overlayFlagName, nil, "overlays defined in the configuration that are applied in order")
+
 	AmalgomateCmd.Flags().StringVar(&outputDirVal, outputDirFlagName, "", "directory in which amalgomated output is written")
 	if err := AmalgomateCmd.MarkFlagRequired(outputDirFlagName); err != nil {
 		panic(err)
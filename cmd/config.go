@@ -0,0 +1,48 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"github.com/palantir/amalgomate/amalgomate"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect amalgomate configuration",
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print the fully merged configuration",
+	Long: `Prints the configuration that results from merging all of the
+files included by the specified configuration file and applying
+the specified overlays. The configuration is printed as YAML.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := amalgomate.LoadConfig(configFlagVal, overlayFlagVal...)
+		if err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(cfg)
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal configuration")
+		}
+		_, err = cmd.OutOrStdout().Write(out)
+		return err
+	},
+}
+
+func init() {
+	configPrintCmd.Flags().StringVar(&configFlagVal, configFlagName, "", "configuration file to print")
+	if err := configPrintCmd.MarkFlagRequired(configFlagName); err != nil {
+		panic(err)
+	}
+	configPrintCmd.Flags().StringSliceVar(&overlayFlagVal, overlayFlagName, nil, "overlays defined in the configuration that are applied in order")
+
+	configCmd.AddCommand(configPrintCmd)
+	AmalgomateCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2026 Palantir Technologies Inc. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"github.com/palantir/amalgomate/amalgomate"
+	"github.com/spf13/cobra"
+)
+
+var testCmd = &cobra.Command{
+	Use:   "test [flags] [programs] [-- go test flags]",
+	Short: "Run the repackaged tests of amalgomated programs",
+	Long: `Runs "go test" on the repackaged modules of the specified programs,
+which must set "copy-tests" in the configuration. If no programs are
+specified, the tests of all of the programs that set "copy-tests" are
+run. amalgomate must have been run with the same configuration and
+output directory before running this command. Arguments after "--" are
+passed to "go test".
+
+An example invocation is of the form:
+
+  amalgomate test --config config.yml --output-dir generated_src gofmt -- -race`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		programs, goTestArgs := args, []string(nil)
+		if dashIdx := cmd.ArgsLenAtDash(); dashIdx != -1 {
+			programs, goTestArgs = args[:dashIdx], args[dashIdx:]
+		}
+		cfg, err := amalgomate.LoadConfig(configFlagVal, overlayFlagVal...)
+		if err != nil {
+			return err
+		}
+		return amalgomate.RunTests(cfg, outputDirVal, programs, goTestArgs)
+	},
+}
+
+func init() {
+	testCmd.Flags().StringVar(&configFlagVal, configFlagName, "", "configuration file that specifies packages that were amalgomated")
+	if err := testCmd.MarkFlagRequired(configFlagName); err != nil {
+		panic(err)
+	}
+	testCmd.Flags().StringSliceVar(&overlayFlagVal, overlayFlagName, nil, "overlays defined in the configuration that are applied in order")
+
+	testCmd.Flags().StringVar(&outputDirVal, outputDirFlagName, "", "directory in which amalgomated output was written")
+	if err := testCmd.MarkFlagRequired(outputDirFlagName); err != nil {
+		panic(err)
+	}
+
+	AmalgomateCmd.AddCommand(testCmd)
+}
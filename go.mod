@@ -9,9 +9,11 @@ require (
 	github.com/nmiyake/pkg/gofiles v1.2.0
 	github.com/otiai10/copy v1.14.1
 	github.com/palantir/pkg/cobracli v1.3.0
+	github.com/pelletier/go-toml/v2 v2.2.4
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/cobra v1.10.2
 	github.com/stretchr/testify v1.12.1
+	golang.org/x/mod v0.40.0
 	golang.org/x/tools v0.49.0
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -23,7 +25,6 @@ require (
 	github.com/palantir/pkg v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.10 // indirect
 	go.yaml.in/yaml/v3 v3.0.5 // indirect
-	golang.org/x/mod v0.40.0 // indirect
 	golang.org/x/sync v0.22.0 // indirect
 	golang.org/x/sys v0.47.0 // indirect
 )